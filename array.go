@@ -0,0 +1,202 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Array types, as produced by table returning functions and extensions.
+const (
+	mdb_TINYINT_ARRAY  = "tinyint[]"
+	mdb_SMALLINT_ARRAY = "smallint[]"
+	mdb_INT_ARRAY      = "int[]"
+	mdb_BIGINT_ARRAY   = "bigint[]"
+	mdb_REAL_ARRAY     = "real[]"
+	mdb_DOUBLE_ARRAY   = "double[]"
+)
+
+func init() {
+	for _, t := range []string{mdb_TINYINT_ARRAY, mdb_SMALLINT_ARRAY, mdb_INT_ARRAY, mdb_BIGINT_ARRAY} {
+		toGoMappers[t] = toInt64Array
+	}
+	for _, t := range []string{mdb_REAL_ARRAY, mdb_DOUBLE_ARRAY} {
+		toGoMappers[t] = toFloat64Array
+	}
+	for _, t := range []string{"[]int64", "[]float64", "monetdb.Int64Array", "monetdb.Float64Array"} {
+		toMonetMappers[t] = toArrayString
+	}
+}
+
+// Int64Array is a Scan destination for an array of integers, such as
+// "[1, 2, 3]". Scanning an array with a NULL element fails, scan into an
+// interface{} to get the elements as int64 or nil instead.
+type Int64Array []int64
+
+// Float64Array is a Scan destination for an array of numbers, see
+// Int64Array.
+type Float64Array []float64
+
+// Scan implements sql.Scanner.
+func (a *Int64Array) Scan(src interface{}) error {
+	elems, err := scanArray(src, toInt64Array)
+	if err != nil || elems == nil {
+		*a = nil
+		return err
+	}
+
+	r := make(Int64Array, len(elems))
+	for i, e := range elems {
+		v, ok := e.(int64)
+		if !ok {
+			return fmt.Errorf("Cannot scan %T element into Int64Array", e)
+		}
+		r[i] = v
+	}
+	*a = r
+	return nil
+}
+
+// Scan implements sql.Scanner.
+func (a *Float64Array) Scan(src interface{}) error {
+	elems, err := scanArray(src, toFloat64Array)
+	if err != nil || elems == nil {
+		*a = nil
+		return err
+	}
+
+	r := make(Float64Array, len(elems))
+	for i, e := range elems {
+		switch v := e.(type) {
+		case float64:
+			r[i] = v
+		case int64:
+			r[i] = float64(v)
+		default:
+			return fmt.Errorf("Cannot scan %T element into Float64Array", e)
+		}
+	}
+	*a = r
+	return nil
+}
+
+// scanArray returns the elements of a scanned array, which is either
+// converted already or given as text, which is parsed with conv.
+func scanArray(src interface{}, conv toGoConverter) ([]interface{}, error) {
+	switch v := src.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	case []byte:
+		src = string(v)
+	}
+	s, ok := src.(string)
+	if !ok {
+		return nil, fmt.Errorf("Cannot scan %T into an array", src)
+	}
+	v, err := conv(s)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]interface{}), nil
+}
+
+// splitArray returns the elements of an array such as "[1, NULL, 3]".
+func splitArray(v string) ([]string, error) {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	if len(v) < 2 || v[0] != '[' || v[len(v)-1] != ']' {
+		return nil, fmt.Errorf("Invalid array: %s", v)
+	}
+
+	v = strings.TrimSpace(v[1 : len(v)-1])
+	if v == "" {
+		return []string{}, nil
+	}
+	elems := strings.Split(v, ",")
+	for i, e := range elems {
+		elems[i] = strings.TrimSpace(e)
+	}
+	return elems, nil
+}
+
+// toInt64Array converts an array of integers to a slice of int64 values,
+// with nil for NULL elements.
+func toInt64Array(v string) (driver.Value, error) {
+	elems, err := splitArray(v)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]interface{}, len(elems))
+	for i, e := range elems {
+		if e == mdb_NULL {
+			continue
+		}
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid array element: %s", e)
+		}
+		r[i] = n
+	}
+	return r, nil
+}
+
+// toFloat64Array converts an array of numbers to a slice of float64
+// values, with nil for NULL elements.
+func toFloat64Array(v string) (driver.Value, error) {
+	elems, err := splitArray(v)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]interface{}, len(elems))
+	for i, e := range elems {
+		if e == mdb_NULL {
+			continue
+		}
+		f, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid array element: %s", e)
+		}
+		r[i] = f
+	}
+	return r, nil
+}
+
+// toArrayString converts a slice of numbers to an array literal such as
+// '[1, 2, 3]', and a nil slice to NULL.
+func toArrayString(v driver.Value) (string, error) {
+	var elems []string
+	switch val := v.(type) {
+	case []int64:
+		if val == nil {
+			return toNull(nil)
+		}
+		for _, n := range val {
+			elems = append(elems, strconv.FormatInt(n, 10))
+		}
+	case Int64Array:
+		return toArrayString([]int64(val))
+	case []float64:
+		if val == nil {
+			return toNull(nil)
+		}
+		for _, f := range val {
+			elems = append(elems, strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case Float64Array:
+		return toArrayString([]float64(val))
+	default:
+		return "", fmt.Errorf("Unsupported type")
+	}
+	return "'[" + strings.Join(elems, ", ") + "]'", nil
+}
@@ -0,0 +1,81 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInt64ArrayRoundTrip(t *testing.T) {
+	for _, a := range [][]int64{{1, -2, 3}, {}} {
+		s, err := convertToMonet(a)
+		if err != nil {
+			t.Fatalf("Error converting %v: %v", a, err)
+		}
+		v, err := convertToGo(strings.Trim(s, "'"), "bigint[]")
+		if err != nil {
+			t.Fatalf("Error converting %s back: %v", s, err)
+		}
+		var r Int64Array
+		if err := r.Scan(v); err != nil {
+			t.Fatalf("Error scanning %v: %v", v, err)
+		}
+		if !reflect.DeepEqual([]int64(r), a) {
+			t.Errorf("Invalid round trip: %v, expected: %v", r, a)
+		}
+	}
+}
+
+func TestFloat64ArrayRoundTrip(t *testing.T) {
+	for _, a := range []Float64Array{{1.5, -2, 1e-9}, {}} {
+		s, err := convertToMonet(a)
+		if err != nil {
+			t.Fatalf("Error converting %v: %v", a, err)
+		}
+		v, err := convertToGo(strings.Trim(s, "'"), "double[]")
+		if err != nil {
+			t.Fatalf("Error converting %s back: %v", s, err)
+		}
+		var r Float64Array
+		if err := r.Scan(v); err != nil {
+			t.Fatalf("Error scanning %v: %v", v, err)
+		}
+		if !reflect.DeepEqual(r, a) {
+			t.Errorf("Invalid round trip: %v, expected: %v", r, a)
+		}
+	}
+}
+
+func TestConvertArray(t *testing.T) {
+	v, err := convertToGo("[1, NULL, 3]", "int[]")
+	if err != nil {
+		t.Fatalf("Error converting array: %v", err)
+	}
+	if e := []interface{}{int64(1), nil, int64(3)}; !reflect.DeepEqual(v, e) {
+		t.Errorf("Invalid array: %v, expected: %v", v, e)
+	}
+
+	var a Int64Array
+	if err := a.Scan(v); err == nil {
+		t.Errorf("Expected error scanning NULL element")
+	}
+	if err := a.Scan("[4,5]"); err != nil || !reflect.DeepEqual(a, Int64Array{4, 5}) {
+		t.Errorf("Invalid array scanned from text: %v (%v)", a, err)
+	}
+	if err := a.Scan(nil); err != nil || a != nil {
+		t.Errorf("Invalid array scanned from NULL: %v (%v)", a, err)
+	}
+
+	if s, _ := convertToMonet([]int64(nil)); s != "NULL" {
+		t.Errorf("Invalid nil array: %s, expected: NULL", s)
+	}
+	for _, v := range []string{"1, 2", "[1, x]", "[1.5]"} {
+		if _, err := convertToGo(v, "int[]"); err == nil {
+			t.Errorf("Expected error converting %q", v)
+		}
+	}
+}
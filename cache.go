@@ -0,0 +1,98 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"container/list"
+)
+
+// preparedStmt is a statement prepared on the server.
+type preparedStmt struct {
+	query           string
+	execId          int
+	paramTypes      []string
+	paramConverters []toMonetConverter
+
+	// mapi is the session the statement was prepared in, or nil once
+	// it is gone from the server.
+	mapi *MapiConn
+	// users is the number of Stmts using the statement, and cached
+	// whether the cache holds it. It is deallocated on the server once
+	// neither is the case, see Conn.deallocate.
+	users  int
+	cached bool
+}
+
+// stmtCache keeps the most recently used prepared statements of
+// a connection, so preparing the same query again reuses the
+// statement on the server.
+type stmtCache struct {
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the prepared statement for the query, if it is cached.
+func (c *stmtCache) get(query string) (*preparedStmt, bool) {
+	if e, ok := c.entries[query]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*preparedStmt), true
+	}
+	return nil, false
+}
+
+// put adds a prepared statement, evicting the least recently used one
+// if the cache is full. It returns the statements the cache no longer
+// holds, which are to be deallocated unless a Stmt still uses them.
+func (c *stmtCache) put(p *preparedStmt) []*preparedStmt {
+	if c.size <= 0 {
+		return []*preparedStmt{p}
+	}
+	p.cached = true
+	if e, ok := c.entries[p.query]; ok {
+		old := e.Value.(*preparedStmt)
+		old.cached = false
+		e.Value = p
+		c.order.MoveToFront(e)
+		return []*preparedStmt{old}
+	}
+
+	c.entries[p.query] = c.order.PushFront(p)
+	if c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		old := e.Value.(*preparedStmt)
+		old.cached = false
+		delete(c.entries, old.query)
+		return []*preparedStmt{old}
+	}
+	return nil
+}
+
+// remove drops the prepared statement for the query.
+func (c *stmtCache) remove(query string) {
+	if e, ok := c.entries[query]; ok {
+		e.Value.(*preparedStmt).cached = false
+		c.order.Remove(e)
+		delete(c.entries, query)
+	}
+}
+
+// clear drops all prepared statements, as they are gone with the session.
+func (c *stmtCache) clear() {
+	for _, e := range c.entries {
+		e.Value.(*preparedStmt).cached = false
+	}
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
@@ -0,0 +1,226 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStmtCacheEviction(t *testing.T) {
+	c := newStmtCache(2)
+	c.put(&preparedStmt{query: "a", execId: 1})
+	c.put(&preparedStmt{query: "b", execId: 2})
+	c.get("a")
+	c.put(&preparedStmt{query: "c", execId: 3})
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("Least recently used statement not evicted")
+	}
+	for _, q := range []string{"a", "c"} {
+		if _, ok := c.get(q); !ok {
+			t.Errorf("Statement evicted: %s", q)
+		}
+	}
+
+	c.clear()
+	if _, ok := c.get("a"); ok {
+		t.Errorf("Statement not removed by clear")
+	}
+}
+
+// countingServer answers like prepareServer and counts the PREPAREs.
+func countingServer(prepares *int32) func(*MapiConn) {
+	return serveCommands(func(cmd string) string {
+		if strings.HasPrefix(cmd, "sPREPARE ") {
+			atomic.AddInt32(prepares, 1)
+			return prepareResponse
+		}
+		return "&2 1 -1\n"
+	})
+}
+
+func execRepeatedly(db *sql.DB, n int) (int64, error) {
+	var total int64
+	for i := 0; i < n; i++ {
+		stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+		if err != nil {
+			return 0, err
+		}
+		res, err := stmt.Exec(i, 1.5, "x")
+		stmt.Close()
+		if err != nil {
+			return 0, err
+		}
+		affected, _ := res.RowsAffected()
+		total += affected
+	}
+	return total, nil
+}
+
+func TestStmtCache(t *testing.T) {
+	for _, size := range []int{0, 10} {
+		var prepares int32
+		srv := newFakeServer(t, countingServer(&prepares))
+		defer srv.Close()
+
+		db, err := sql.Open("monetdb", srv.dsn()+"?statement_cache_size="+strconv.Itoa(size))
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		total, err := execRepeatedly(db, 5)
+		if err != nil {
+			t.Fatalf("Error executing: %v", err)
+		}
+		if total != 5 {
+			t.Errorf("Invalid rows affected: %d, expected: %d", total, 5)
+		}
+
+		expected := int32(5)
+		if size > 0 {
+			expected = 1
+		}
+		if prepares != expected {
+			t.Errorf("Invalid number of PREPAREs: %d, expected: %d (cache size %d)", prepares, expected, size)
+		}
+	}
+}
+
+func TestStmtCacheReprepare(t *testing.T) {
+	cmds := make(chan string, 20)
+	altered := false
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		switch {
+		case strings.HasPrefix(cmd, "sALTER "):
+			altered = true
+			return "&3\n"
+		case strings.HasPrefix(cmd, "sPREPARE "):
+			if altered {
+				return strings.Replace(prepareResponse, "&5 3 ", "&5 4 ", 1)
+			}
+			return prepareResponse
+		case strings.HasPrefix(cmd, "sEXECUTE 3(") && altered:
+			return "!07003!EXECUTE: PREPARED Statement missing 3\n"
+		}
+		return "&2 1 -1\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?statement_cache_size=10")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := execRepeatedly(db, 1); err != nil {
+		t.Fatalf("Error executing: %v", err)
+	}
+	if _, err := db.Exec("ALTER TABLE t ADD COLUMN d int"); err != nil {
+		t.Fatalf("Error altering table: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := execRepeatedly(db, 1); err != nil {
+			t.Fatalf("Error executing after altering the table: %v", err)
+		}
+	}
+
+	expectCommands(t, cmds,
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 3(0, 1.5, 'x');",
+		"sALTER TABLE t ADD COLUMN d int;",
+		"sEXECUTE 3(0, 1.5, 'x');",
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 4(0, 1.5, 'x');",
+		"sEXECUTE 4(0, 1.5, 'x');")
+}
+
+func TestStmtDeallocate(t *testing.T) {
+	for _, c := range []struct {
+		size     int
+		expected []string
+	}{
+		{0, []string{
+			"sPREPARE SELECT ?;",
+			"sEXECUTE 3(1);",
+			"sDEALLOCATE 3;",
+			"sPREPARE SELECT ?, ?;",
+			"sEXECUTE 4(1, 2);",
+			"sDEALLOCATE 4;",
+		}},
+		{1, []string{
+			"sPREPARE SELECT ?;",
+			"sEXECUTE 3(1);",
+			"sPREPARE SELECT ?, ?;",
+			"sDEALLOCATE 3;",
+			"sEXECUTE 4(1, 2);",
+		}},
+	} {
+		cmds := make(chan string, 20)
+		execId := 2
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			cmds <- cmd
+			if strings.HasPrefix(cmd, "sPREPARE ") {
+				execId++
+				return strings.Replace(prepareResponse, "&5 3 ", "&5 "+strconv.Itoa(execId)+" ", 1)
+			}
+			return "&2 1 -1\n"
+		}))
+		defer srv.Close()
+
+		db, err := sql.Open("monetdb", srv.dsn()+"?statement_cache_size="+strconv.Itoa(c.size))
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		for _, q := range []string{"SELECT ?", "SELECT ?, ?"} {
+			stmt, err := db.Prepare(q)
+			if err != nil {
+				t.Fatalf("Error preparing statement: %v", err)
+			}
+			args := []interface{}{1, 2}[:strings.Count(q, "?")]
+			if _, err := stmt.Exec(args...); err != nil {
+				t.Fatalf("Error executing statement: %v", err)
+			}
+			if err := stmt.Close(); err != nil {
+				t.Fatalf("Error closing statement: %v", err)
+			}
+		}
+		expectCommands(t, cmds, c.expected...)
+	}
+}
+
+func benchmarkStmtCache(b *testing.B, size int) {
+	var prepares int32
+	srv := newFakeServer(b, countingServer(&prepares))
+	defer srv.Close()
+
+	db, _ := sql.Open("monetdb", srv.dsn()+"?statement_cache_size="+strconv.Itoa(size))
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	b.ResetTimer()
+	if _, err := execRepeatedly(db, b.N); err != nil {
+		b.Fatalf("Error executing: %v", err)
+	}
+	b.ReportMetric(float64(b.N+int(prepares))/float64(b.N), "roundtrips/op")
+}
+
+func BenchmarkStmtUncached(b *testing.B) {
+	benchmarkStmtCache(b, 0)
+}
+
+func BenchmarkStmtCached(b *testing.B) {
+	benchmarkStmtCache(b, 10)
+}
@@ -0,0 +1,38 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"strings"
+)
+
+type commentKey struct{}
+
+// WithComment returns a context that makes the driver prepend comment as
+// a SQL comment to the statements run with it, e.g. "traceid=abc" is sent
+// as "/* traceid=abc */ SELECT ...". The comment shows up in the query
+// log of the server, where it can be used to correlate queries.
+//
+// Any "*/" or "/*" in the comment is broken up, so it cannot end the
+// comment early.
+func WithComment(ctx context.Context, comment string) context.Context {
+	return context.WithValue(ctx, commentKey{}, comment)
+}
+
+// commentFor returns the comment of ctx in the form it is prepended to a
+// statement, or "" if it has none.
+func commentFor(ctx context.Context) string {
+	c, ok := ctx.Value(commentKey{}).(string)
+	if !ok || c == "" {
+		return ""
+	}
+	// each replacement inserts a space, so this ends
+	for strings.Contains(c, "*/") || strings.Contains(c, "/*") {
+		c = strings.ReplaceAll(c, "*/", "* /")
+		c = strings.ReplaceAll(c, "/*", "/ *")
+	}
+	return "/* " + c + " */ "
+}
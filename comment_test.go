@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestWithComment(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := WithComment(context.Background(), "traceid=abc")
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES (?)", 1); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO t VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	if _, err := stmt.Exec(1, 2.5, "x"); err != nil {
+		t.Fatalf("Error executing statement: %v", err)
+	}
+	stmt.Close()
+
+	evil := WithComment(context.Background(), "x */ DROP TABLE t; /*/ y")
+	if _, err := db.ExecContext(evil, "DELETE FROM t"); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"s/* traceid=abc */ INSERT INTO t VALUES (1);",
+		"s/* traceid=abc */ PREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"s/* traceid=abc */ EXECUTE 3(1, 2.5, 'x');",
+		"sDEALLOCATE 3;",
+		"s/* x * / DROP TABLE t; / * / y */ DELETE FROM t;")
+}
+
+func TestCommentFor(t *testing.T) {
+	type tc struct {
+		comment  string
+		expected string
+	}
+	var tcs = []tc{
+		tc{"", ""},
+		tc{"a", "/* a */ "},
+		tc{"*/", "/* * / */ "},
+		tc{"*/*/", "/* * / * / */ "},
+		tc{"/*/", "/* / * / */ "},
+	}
+	for _, c := range tcs {
+		ctx := WithComment(context.Background(), c.comment)
+		if got := commentFor(ctx); got != c.expected {
+			t.Errorf("Expected %q for %q, got %q", c.expected, c.comment, got)
+		}
+	}
+	if got := commentFor(context.Background()); got != "" {
+		t.Errorf("Expected no comment, got %q", got)
+	}
+}
@@ -5,65 +5,788 @@
 package monetdb
 
 import (
+	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// ErrStatementTooLarge is returned for statements larger than the
+// max_statement_size DSN option allows.
+var ErrStatementTooLarge = errors.New("Statement too large")
+
+// ErrNoHugeint is returned when a server built without the 128 bit hugeint
+// type rejects a *big.Int argument outside the int64 range.
+var ErrNoHugeint = errors.New("Server has no hugeint type")
+
+// Conn is a connection to MonetDB. Besides the database/sql/driver
+// interfaces, it has methods for features database/sql has no API for,
+// such as ServerVersion, Status, MapiCommand and CopyFromReader. They are
+// reached through sql.Conn.Raw:
+//
+//	err := conn.Raw(func(dc interface{}) error {
+//		version, err := dc.(*monetdb.Conn).ServerVersion()
+//		...
+//	})
 type Conn struct {
-	config config
+	config Config
 	mapi   *MapiConn
+
+	// inTx is set while a transaction started by Begin is open.
+	inTx bool
+
+	// toGoMappers holds the converters this connection uses
+	// instead of the default ones.
+	toGoMappers map[string]toGoConverter
+
+	stmtCache *stmtCache
+
+	// serverVersion caches the result of ServerVersion.
+	serverVersion string
 }
 
 var FirstUseFunction = func(c *MapiConn) {
 }
 
-func newConn(c config) (*Conn, error) {
+func newConn(ctx context.Context, c Config) (*Conn, error) {
 	conn := &Conn{
-		config: c,
-		mapi:   nil,
+		config:      c,
+		mapi:        nil,
+		toGoMappers: connToGoMappers(c),
+		stmtCache:   newStmtCache(c.StatementCacheSize),
 	}
 
-	m := NewMapi(c.Hostname, c.Port, c.Username, c.Password, c.Database, "sql")
-	err := m.Connect()
+	m := NewMapi(c.Hostname, c.Port, c.Username, c.Password, c.Database, conn.language())
+	m.BlockSize = c.BlockSize
+	m.ReadBuffer = c.ReadBuffer
+	m.WriteBuffer = c.WriteBuffer
+	err := connect(ctx, m, c)
 	if err != nil {
 		return conn, err
 	}
 
 	conn.mapi = m
+	if conn.language() == "mal" {
+		// the client info and session setup are SQL commands
+		FirstUseFunction(conn.mapi)
+		return conn, nil
+	}
+
+	stop := m.watchContext(ctx)
+	err = conn.sendClientInfo()
+	if err == nil {
+		err = conn.setupSession()
+	}
+	stop()
+	if err != nil {
+		m.Disconnect()
+		if ctx.Err() != nil {
+			return conn, ctx.Err()
+		}
+		return conn, err
+	}
+
 	FirstUseFunction(conn.mapi)
 	return conn, nil
 }
 
+// connect connects to the server, retrying as configured while it cannot
+// be reached.
+func connect(ctx context.Context, m *MapiConn, c Config) error {
+	interval := c.ConnectRetryInterval
+	for attempt := 0; ; attempt++ {
+		err := m.ConnectContext(ctx)
+		var oe *net.OpError
+		if err == nil || attempt >= c.ConnectRetries || !errors.As(err, &oe) || oe.Op != "dial" {
+			return err
+		}
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		interval *= 2
+	}
+}
+
+// sendClientInfo tells the server who is connecting, so the session can
+// be attributed in sys.sessions. Servers that do not announce support for
+// it are skipped.
+func (c *Conn) sendClientInfo() error {
+	if !c.mapi.hasOption("CLIENTINFO") {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	info := []string{
+		"ClientHostname=" + hostname,
+		"ApplicationName=" + c.config.ApplicationName,
+		"ClientLibrary=go-monetdb",
+		fmt.Sprintf("ClientPid=%d", os.Getpid()),
+	}
+	if c.config.ClientRemark != "" {
+		info = append(info, "ClientRemark="+c.config.ClientRemark)
+	}
+
+	var b strings.Builder
+	b.WriteString("Xclientinfo ")
+	for _, i := range info {
+		// each value is on a line of its own
+		b.WriteString(strings.Replace(i, "\n", " ", -1))
+		b.WriteString("\n")
+	}
+
+	_, err := c.cmd(b.String())
+	return err
+}
+
 func (c *Conn) Prepare(query string) (driver.Stmt, error) {
 	return newStmt(c, query), nil
 }
 
+// PrepareContext implements driver.ConnPrepareContext. The statement
+// carries the comment set with WithComment, the fetch size set with
+// WithFetchSize and the retry set with WithRetryIdempotent on ctx, if any.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s := newStmt(c, query)
+	s.comment = commentFor(ctx)
+	s.fetchSize = fetchSizeFor(ctx)
+	s.retryIdempotent = retryIdempotentFor(ctx)
+	return s, nil
+}
+
+// Exec implements driver.Execer. The arguments are substituted into the
+// query on the client, so the statement takes a single round-trip instead
+// of a PREPARE and an EXECUTE. If the placeholders don't match the
+// arguments, driver.ErrSkip makes database/sql prepare the statement
+// instead, which lets the server report the mismatch.
+//
+// The arguments are converted like those of a query without parameter
+// types, not with the conversions a prepared statement picks for the type
+// of each parameter: a bool is written as true or false, also for an
+// integer column (see BoolAsInt), and a string is left for the server to
+// cast. Registered converters, Raw and Decimal apply either way. Prepare
+// the statement to have the arguments converted to the parameter types.
+func (c *Conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.exec(query, args, "")
+}
+
+// ExecContext implements driver.ExecerContext, see Exec. The comment set
+// with WithComment on ctx, if any, is prepended to the statement. The
+// statement gives up when ctx is done; the connection is closed then, as
+// the reply may still arrive, so database/sql discards it.
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.mapi == nil {
+		return nil, driver.ErrBadConn
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	res, err := c.exec(query, values, commentFor(ctx))
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return res, err
+}
+
+// namedValues returns the values of args, which must not be named, see
+// NamedArgs for named placeholders.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, fmt.Errorf("Named arguments are not supported: %s", a.Name)
+		}
+		values[i] = a.Value
+	}
+	return values, nil
+}
+
+func (c *Conn) exec(query string, args []driver.Value, comment string) (driver.Result, error) {
+	named, ok, err := namedArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		var names []string
+		query, names = parseNamed(query)
+		if args, err = bindNamed(names, named); err != nil {
+			return nil, err
+		}
+	}
+
+	q, ok, err := interpolate(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	s := newStmt(c, query)
+	start := time.Now()
+	r, err := c.execute(comment + q)
+	return s.result(start, r, hugeintError(args, err))
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, see
+// Stmt.CheckNamedValue.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	return (*Stmt)(nil).CheckNamedValue(nv)
+}
+
+// Close closes the connection. MAPI has no message to end a session, the
+// server ends it as soon as it reads the end of the stream. Closing a
+// connection again does nothing.
 func (c *Conn) Close() error {
+	if c.mapi == nil {
+		return nil
+	}
 	c.mapi.Disconnect()
 	c.mapi = nil
+	c.stmtCache.clear()
 	return nil
 }
 
 func (c *Conn) Begin() (driver.Tx, error) {
 	t := newTx(c)
 
-	_, err := c.execute("START TRANSACTION")
-	if err != nil {
-		t.err = err
+	// Without autocommit the session is always in a transaction,
+	// which ends with the next COMMIT or ROLLBACK. With autocommit, it
+	// is turned off until the transaction ends, see endTx.
+	if !c.config.NoAutocommit {
+		if _, err := c.cmd("Xauto_commit 0"); err != nil {
+			t.err = err
+			return t, t.err
+		}
+		c.inTx = true
+		if c.config.ReadOnly {
+			if _, err := c.execute("SET TRANSACTION READ ONLY"); err != nil {
+				c.inTx = false
+				c.cmd("Xauto_commit 1")
+				t.err = err
+				return t, t.err
+			}
+		}
 	}
+	c.inTx = true
 
 	return t, t.err
 }
 
+// ResetSession is called by database/sql before the connection is reused.
+// A connection the server closed, e.g. because it was idle for too long,
+// is reported as bad, so database/sql uses another one. A transaction that
+// was left open is rolled back, so the next user starts in autocommit
+// mode.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	if c.mapi == nil || !c.mapi.isAlive() {
+		return driver.ErrBadConn
+	}
+	if c.inTx {
+		if err := c.endTx("ROLLBACK"); err != nil {
+			return driver.ErrBadConn
+		}
+	}
+	return nil
+}
+
+// endTx ends the transaction started with Begin with q, COMMIT or
+// ROLLBACK. Autocommit is turned back on if Begin turned it off, also when
+// q fails. Without autocommit the next transaction starts right away, so a
+// read-only session makes it read-only again.
+func (c *Conn) endTx(q string) error {
+	_, err := c.execute(q)
+	c.inTx = false
+	if !c.config.NoAutocommit {
+		if _, rerr := c.cmd("Xauto_commit 1"); err == nil && rerr != nil {
+			err = fmt.Errorf("Enabling autocommit failed: %w", rerr)
+		}
+	} else if c.config.ReadOnly {
+		if _, rerr := c.execute("SET TRANSACTION READ ONLY"); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Status reports whether a transaction started with Begin is open, and
+// whether the session is in autocommit mode outside transactions. It is
+// reached through sql.Conn.Raw, e.g. to check that no transaction is left
+// open when a connection is returned to the pool.
+func (c *Conn) Status() (inTx bool, autocommit bool) {
+	return c.inTx, !c.config.NoAutocommit
+}
+
+// IsValid implements driver.Validator. It lets database/sql discard a
+// pooled connection the server or the network has closed.
+func (c *Conn) IsValid() bool {
+	return c.mapi != nil && c.mapi.isAlive()
+}
+
+// Ping implements driver.Pinger. It runs a trivial query, which gives up
+// when ctx is done. The connection is closed then, as the reply may still
+// arrive, so database/sql discards it.
+func (c *Conn) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.mapi == nil {
+		return driver.ErrBadConn
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	_, err := c.execute("SELECT 1")
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && c.mapi.State != MAPI_STATE_READY {
+		return driver.ErrBadConn
+	}
+	return err
+}
+
+// setupSession applies the session settings of the configuration.
+func (c *Conn) setupSession() error {
+	if c.config.NoAutocommit {
+		if _, err := c.cmd("Xauto_commit 0"); err != nil {
+			return fmt.Errorf("Disabling autocommit failed: %w", err)
+		}
+	}
+
+	if c.config.TimeZone != nil {
+		_, offset := time.Now().In(c.config.TimeZone).Zone()
+		sign := '+'
+		if offset < 0 {
+			sign = '-'
+			offset = -offset
+		}
+		q := fmt.Sprintf("SET TIME ZONE INTERVAL '%c%02d:%02d' HOUR TO MINUTE",
+			sign, offset/3600, offset%3600/60)
+		if _, err := c.execute(q); err != nil {
+			return fmt.Errorf("Setting time zone failed: %w", err)
+		}
+	}
+
+	// with autocommit, execute makes every statement read-only instead
+	if c.config.ReadOnly && c.config.NoAutocommit {
+		if _, err := c.execute("SET TRANSACTION READ ONLY"); err != nil {
+			return fmt.Errorf("Making session read-only failed: %w", err)
+		}
+	}
+
+	if c.config.QueryTimeout > 0 {
+		seconds := (c.config.QueryTimeout + time.Second - 1) / time.Second
+		q := fmt.Sprintf("CALL sys.setquerytimeout(%d)", seconds)
+		if _, err := c.execute(q); err != nil {
+			return fmt.Errorf("Setting query timeout failed: %w", err)
+		}
+	}
+
+	names := make([]string, 0, len(c.config.Settings))
+	for name := range c.config.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, _ := toQuotedString(c.config.Settings[name])
+		if _, err := c.execute(fmt.Sprintf("SET %s=%s", name, v)); err != nil {
+			return fmt.Errorf("Setting %s failed: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// ChangePassword changes the password of the current user. The session
+// stays usable and reconnects use the new password. It is reached through
+// sql.Conn.Raw. If ctx is done before the server answers, the connection
+// is closed and ctx.Err() is returned.
+func (c *Conn) ChangePassword(ctx context.Context, oldPassword, newPassword string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.mapi == nil {
+		return driver.ErrBadConn
+	}
+
+	o, _ := toQuotedString(oldPassword)
+	n, _ := toQuotedString(newPassword)
+	stop := c.mapi.watchContext(ctx)
+	_, err := c.execute(fmt.Sprintf("ALTER USER SET PASSWORD %s USING OLD PASSWORD %s", n, o))
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("Changing password failed: %w", err)
+	}
+
+	c.config.Password = newPassword
+	c.mapi.Password = newPassword
+	return nil
+}
+
+// MapiCommand sends a MAPI control command, such as "Xreply_size 100",
+// and returns the response of the server. It is reached through
+// sql.Conn.Raw and meant for tooling and tests: the command is sent as
+// is, so it can change the state of the session behind the back of the
+// driver, e.g. by turning off autocommit.
+func (c *Conn) MapiCommand(ctx context.Context, cmd string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if c.mapi == nil {
+		return "", driver.ErrBadConn
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	r, err := c.cmd(cmd)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return r, err
+}
+
+// ForEachRow runs a query and calls fn with the values of each row of its
+// result, as converted by the driver, until fn returns an error, which is
+// then returned. Rows are fetched as they are needed, and the slice
+// passed to fn is reused for every row, so it must not be kept. It is
+// reached through sql.Conn.Raw, and avoids the overhead of Scan for
+// large results.
+func (c *Conn) ForEachRow(ctx context.Context, query string, args []driver.Value, fn func(row []driver.Value) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.mapi == nil {
+		return driver.ErrBadConn
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	defer stop()
+
+	s := newStmt(c, query)
+	dr, err := s.Query(args)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	rows := dr.(*Rows)
+	defer rows.Close()
+
+	row := make([]driver.Value, len(rows.description))
+	for {
+		err := rows.Next(row)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// ExecBatch executes a statement with each of the sets of arguments and
+// returns the number of rows each execution affected. The statement is
+// prepared once, and its executions are sent to the server in a single
+// request. When one fails, its error is returned with the counts of the
+// executions before it; in autocommit mode these are committed, so run
+// the batch in a transaction if it must succeed as a whole. It is reached
+// through sql.Conn.Raw.
+func (c *Conn) ExecBatch(ctx context.Context, query string, argSets [][]driver.Value) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.mapi == nil {
+		return nil, driver.ErrBadConn
+	}
+	if len(argSets) == 0 {
+		return nil, nil
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	defer stop()
+
+	s := newStmt(c, query)
+	s.comment = commentFor(ctx)
+	if err := s.prepare(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	cmds := make([]string, len(argSets))
+	for i, args := range argSets {
+		cmd, err := s.executeCommand(args)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid arguments in set %d: %w", i+1, err)
+		}
+		cmds[i] = cmd
+	}
+
+	start := time.Now()
+	r, err := c.execute(s.comment + strings.Join(cmds, ";\n"))
+	if err != nil {
+		s.reportQuery(start, 0, err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	counts, total, err := batchCounts(r)
+	if err == nil && len(counts) != len(argSets) {
+		err = fmt.Errorf("Invalid number of results: %d, expected: %d", len(counts), len(argSets))
+	}
+	s.reportQuery(start, total, err)
+	return counts, err
+}
+
+// batchCounts returns the affected row counts of the statements of a
+// response, and their total, up to the first error.
+func batchCounts(r string) ([]int64, int, error) {
+	var counts []int64
+	total := 0
+	for _, line := range strings.Split(r, "\n") {
+		if strings.HasPrefix(line, mapi_MSG_QUPDATE) {
+			t := strings.Fields(line[2:])
+			if len(t) == 0 {
+				continue
+			}
+			n, _ := strconv.Atoi(t[0])
+			counts = append(counts, int64(n))
+			total += n
+		} else if strings.HasPrefix(line, mapi_MSG_ERROR) {
+			return counts, total, fmt.Errorf("Database error: %s", line[1:])
+		}
+	}
+	return counts, total, nil
+}
+
+// ServerVersion returns the version of the MonetDB server, e.g. "11.47.11".
+// It is queried once per connection. It is reached through sql.Conn.Raw.
+func (c *Conn) ServerVersion() (string, error) {
+	if c.serverVersion != "" {
+		return c.serverVersion, nil
+	}
+
+	q := "SELECT value FROM sys.env() WHERE name = 'monet_version'"
+	r, err := c.execute(q)
+	if err != nil {
+		return "", fmt.Errorf("Querying server version failed: %w", err)
+	}
+	s := newStmt(c, q)
+	if err := s.storeResult(r); err != nil {
+		return "", fmt.Errorf("Querying server version failed: %w", err)
+	}
+	if len(s.rows) == 0 || len(s.rows[0]) == 0 {
+		return "", fmt.Errorf("Server version not found")
+	}
+	v, ok := s.rows[0][0].(string)
+	if !ok {
+		return "", fmt.Errorf("Invalid server version: %v", s.rows[0][0])
+	}
+
+	c.serverVersion = v
+	return v, nil
+}
+
+// hugeintError returns ErrNoHugeint for err, the error of a statement
+// with args, if the server rejected a *big.Int argument outside the int64
+// range as out of range (SQLSTATE 22003) although a hugeint holds it.
+// Servers built without the 128 bit hugeint type do so, which their
+// handshake does not tell. Other errors are returned as they are.
+func hugeintError(args []driver.Value, err error) error {
+	if err == nil || !strings.Contains(err.Error(), "22003!") {
+		return err
+	}
+	for _, a := range args {
+		i, ok := a.(*big.Int)
+		if ok && i != nil && !i.IsInt64() && i.BitLen() < 128 {
+			return fmt.Errorf("%w, %s is outside the range of a bigint: %v", ErrNoHugeint, i, err)
+		}
+	}
+	return err
+}
+
 func (c *Conn) cmd(cmd string) (string, error) {
 	if c.mapi == nil {
-		return "", fmt.Errorf("Database connection closed")
+		return "", driver.ErrBadConn
 	}
 
-	return c.mapi.Cmd(cmd)
+	r, err := c.mapi.Cmd(cmd)
+	if c.mapi.State != MAPI_STATE_READY {
+		// the prepared statements are gone with the session
+		c.stmtCache.clear()
+	}
+	return r, err
 }
 
 func (c *Conn) execute(q string) (string, error) {
+	if max := c.config.MaxStatementSize; max > 0 && len(q) > max {
+		return "", fmt.Errorf("%w: %d bytes, the limit is %d bytes; "+
+			"load large amounts of data with COPY INTO or in smaller batches",
+			ErrStatementTooLarge, len(q), max)
+	}
+	if c.language() == "mal" {
+		return c.cmd(q)
+	}
+	if c.config.ReadOnly && !c.config.NoAutocommit && !c.inTx {
+		// SET TRANSACTION only lasts for the next transaction, which in
+		// autocommit mode is the next statement. It is sent along with
+		// the statement, and its "&3" line dropped from the response.
+		r, err := c.cmd(fmt.Sprintf("sSET TRANSACTION READ ONLY;\n%s;", q))
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(r, mapi_MSG_QSCHEMA) {
+			if i := strings.Index(r, "\n"); i >= 0 {
+				r = r[i+1:]
+			} else {
+				r = ""
+			}
+		}
+		if strings.HasPrefix(r, mapi_MSG_ERROR) {
+			return "", fmt.Errorf("Operational error: %s", r[1:])
+		}
+		return r, nil
+	}
 	cmd := fmt.Sprintf("s%s;", q)
 	return c.cmd(cmd)
 }
+
+// deallocate releases a prepared statement on the server once no Stmt
+// uses it and the statement cache does not hold it. A statement of an
+// earlier session is gone already.
+func (c *Conn) deallocate(p *preparedStmt) error {
+	if p.users > 0 || p.cached || p.mapi == nil || p.mapi != c.mapi || c.mapi.State != MAPI_STATE_READY {
+		return nil
+	}
+	p.mapi = nil
+	_, err := c.execute(fmt.Sprintf("DEALLOCATE %d", p.execId))
+	return err
+}
+
+// language returns the language of the session, see Config.Language.
+func (c *Conn) language() string {
+	if c.config.Language == "" {
+		return "sql"
+	}
+	return c.config.Language
+}
+
+// interpolate replaces the ? placeholders of a query with the arguments,
+// converted with convertToMonet. Question marks in string literals,
+// quoted identifiers and comments are left alone. It returns false if the
+// number of placeholders differs from the number of arguments.
+func interpolate(query string, args []driver.Value) (string, bool, error) {
+	var b strings.Builder
+	b.Grow(len(query) + 4*len(args))
+	n := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			j := skipQuoted(query, i)
+			b.WriteString(query[i:j])
+			i = j - 1
+			continue
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			b.WriteString(query[i : i+j])
+			i += j - 1
+			continue
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i
+			} else {
+				j += 4
+			}
+			b.WriteString(query[i : i+j])
+			i += j - 1
+			continue
+		case ch == '?':
+			if n >= len(args) {
+				return "", false, nil
+			}
+			v, err := convertToMonet(args[n])
+			if err != nil {
+				return "", false, err
+			}
+			b.WriteString(v)
+			n++
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	if n != len(args) {
+		return "", false, nil
+	}
+	return b.String(), true, nil
+}
+
+// skipQuoted returns the index just past the string literal or quoted
+// identifier starting at query[i]. A doubled quote escapes the quote, and
+// so does a backslash in a string literal, but not in a quoted identifier
+// or a raw string such as R'C:\dir'.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	escapes := quote == '\'' && !isRawPrefix(query, i)
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if escapes {
+				j++
+			}
+		case quote:
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+// isRawPrefix reports whether the string literal starting at query[i] is
+// a raw string, prefixed with R or r.
+func isRawPrefix(query string, i int) bool {
+	if i == 0 || query[i-1] != 'R' && query[i-1] != 'r' {
+		return false
+	}
+	return i == 1 || !isNameChar(query[i-2])
+}
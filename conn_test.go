@@ -0,0 +1,1465 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func expectCommands(t *testing.T, cmds <-chan string, expected ...string) {
+	for _, e := range expected {
+		if c := <-cmds; c != e {
+			t.Errorf("Invalid command: %s, expected: %s", c, e)
+		}
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"Xauto_commit 0",
+		"sINSERT INTO t VALUES (1);",
+		"sROLLBACK;",
+		"Xauto_commit 1")
+}
+
+// txServer keeps the rows inserted with "INSERT INTO t VALUES (1)" and
+// answers "SELECT COUNT(*) FROM t" with their number, inserting and
+// committing like MonetDB does with and without autocommit.
+func txServer(cmds chan<- string) func(*MapiConn) {
+	committed, pending := 0, 0
+	autocommit := true
+	return serveCommands(func(cmd string) string {
+		cmds <- cmd
+		switch cmd {
+		case "Xauto_commit 0":
+			autocommit = false
+		case "Xauto_commit 1":
+			autocommit = true
+			pending = 0
+		case "sINSERT INTO t VALUES (1);":
+			if autocommit {
+				committed++
+			} else {
+				pending++
+			}
+			return "&2 1 -1\n"
+		case "sCOMMIT;":
+			if autocommit {
+				return "!2DM30!COMMIT: not allowed in auto commit mode\n"
+			}
+			committed += pending
+			pending = 0
+		case "sROLLBACK;":
+			if autocommit {
+				return "!2DM30!ROLLBACK: not allowed in auto commit mode\n"
+			}
+			pending = 0
+		case "sSELECT COUNT(*) FROM t;":
+			return "&1 0 1 1 1\n" +
+				"% .t # table_name\n" +
+				"% c # name\n" +
+				"% bigint # type\n" +
+				"% 1 # length\n" +
+				"[ " + strconv.Itoa(committed+pending) + "\t]\n"
+		}
+		return "&3\n"
+	})
+}
+
+func TestTxAutocommit(t *testing.T) {
+	cmds := make(chan string, 20)
+	srv := newFakeServer(t, txServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	count := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+			t.Fatalf("Error counting: %v", err)
+		}
+		return n
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("Invalid count after rollback: %d, expected: 0", n)
+	}
+
+	// autocommit is back on, the next insert is committed right away
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("Invalid count after insert: %d, expected: 1", n)
+	}
+
+	expectCommands(t, cmds,
+		"Xauto_commit 0",
+		"sINSERT INTO t VALUES (1);",
+		"sROLLBACK;",
+		"Xauto_commit 1",
+		"sSELECT COUNT(*) FROM t;",
+		"sINSERT INTO t VALUES (1);",
+		"sSELECT COUNT(*) FROM t;")
+}
+
+func TestTxCommitFailsRestoresAutocommit(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if cmd == "sCOMMIT;" {
+			return "!40000!COMMIT: transaction is aborted because of concurrency conflicts\n"
+		}
+		return "&3\n"
+	}))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if err := tx.Commit(); err == nil || !strings.Contains(err.Error(), "concurrency conflicts") {
+		t.Errorf("Invalid error: %v, expected: concurrency conflicts", err)
+	}
+	if inTx, autocommit := conn.Status(); inTx || !autocommit {
+		t.Errorf("Invalid status: %v %v, expected: false true", inTx, autocommit)
+	}
+
+	expectCommands(t, cmds, "Xauto_commit 0", "sCOMMIT;", "Xauto_commit 1")
+}
+
+func TestTxCommit(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&4 t\n"))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if !conn.inTx {
+		t.Errorf("Connection not in transaction after Begin")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	if conn.inTx {
+		t.Errorf("Connection still in transaction after Commit")
+	}
+
+	expectCommands(t, cmds, "Xauto_commit 0", "sCOMMIT;", "Xauto_commit 1")
+}
+
+func TestResetSessionRollsBack(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&4 f\n"))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	if _, err := conn.Begin(); err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if err := conn.ResetSession(context.Background()); err != nil {
+		t.Fatalf("Error resetting session: %v", err)
+	}
+	if conn.inTx {
+		t.Errorf("Connection still in transaction after ResetSession")
+	}
+
+	expectCommands(t, cmds, "Xauto_commit 0", "sROLLBACK;", "Xauto_commit 1")
+}
+
+func TestClientInfo(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, func(m *MapiConn) {
+		handshakeChallenge(m, fakeChallenge+"sql=6:CLIENTINFO:")
+		b, _ := m.getBlock()
+		cmds <- string(b)
+		m.putBlock([]byte(""))
+	})
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn() + "?application_name=billing&client_remark=eu-west%0Acanary")
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	cmd := <-cmds
+	if !strings.HasPrefix(cmd, "Xclientinfo ") {
+		t.Errorf("Invalid command: %s, expected: Xclientinfo", cmd)
+	}
+	if !strings.Contains(cmd, "\nApplicationName=billing\n") {
+		t.Errorf("Application name not sent: %s", cmd)
+	}
+	if !strings.HasSuffix(cmd, "\nClientRemark=eu-west canary\n") {
+		t.Errorf("Client remark not sent: %s", cmd)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	for _, lang := range []string{"sql", "mal"} {
+		logins := make(chan string, 1)
+		cmds := make(chan string, 10)
+		srv := newFakeServer(t, func(m *MapiConn) {
+			r, err := handshake(m)
+			logins <- r
+			if err != nil {
+				return
+			}
+			for {
+				b, err := m.getBlock()
+				if err != nil {
+					return
+				}
+				cmds <- string(b)
+				m.putBlock([]byte("&3\n"))
+			}
+		})
+
+		c, err := (&Driver{}).Open(srv.dsn() + "?language=" + lang)
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		if r := <-logins; !strings.HasSuffix(r, ":"+lang+":testdb:") {
+			t.Errorf("Invalid login: %s, expected language: %s", r, lang)
+		}
+
+		conn := c.(*Conn)
+		stmt := "io.print(1);"
+		expected := stmt
+		if lang == "sql" {
+			stmt, expected = "SELECT 1", "sSELECT 1;"
+		}
+		if _, err := conn.execute(stmt); err != nil {
+			t.Errorf("Error executing: %v", err)
+		}
+		for cmd := range cmds {
+			if cmd == expected {
+				break
+			}
+			if lang == "mal" {
+				t.Errorf("Invalid command: %s, expected: %s", cmd, expected)
+				break
+			}
+		}
+		c.Close()
+		srv.Close()
+	}
+}
+
+func TestHugeintSupport(t *testing.T) {
+	large := new(big.Int).Lsh(big.NewInt(1), 70)
+	for _, has := range []bool{false, true} {
+		cmds := make(chan string, 10)
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			cmds <- cmd
+			if !has && strings.Contains(cmd, large.String()) {
+				return "!22003!Integer value too large or not a number (" + large.String() + ")\n"
+			}
+			return "&2 1 -1\n"
+		}))
+
+		db, err := sql.Open("monetdb", srv.dsn())
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+		db.SetMaxOpenConns(1)
+
+		if _, err := db.Exec("INSERT INTO t VALUES (?)", big.NewInt(42)); err != nil {
+			t.Errorf("Error inserting a bigint: %v", err)
+		}
+		_, err = db.Exec("INSERT INTO t VALUES (?)", large)
+		if has && err != nil {
+			t.Errorf("Error inserting a hugeint: %v", err)
+		} else if !has && !errors.Is(err, ErrNoHugeint) {
+			t.Errorf("Invalid error: %v, expected: %v", err, ErrNoHugeint)
+		}
+
+		expectCommands(t, cmds,
+			"sINSERT INTO t VALUES (42);",
+			"sINSERT INTO t VALUES ("+large.String()+");")
+		db.Close()
+		srv.Close()
+		if len(cmds) > 0 {
+			t.Errorf("Unexpected command: %s", <-cmds)
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.Contains(cmd, "'wrong'") {
+			return "!ALTER USER: Access denied\n"
+		}
+		return "&3\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(dc interface{}) error {
+		return dc.(*Conn).ChangePassword(context.Background(), "wrong", "new")
+	})
+	if err == nil || !strings.Contains(err.Error(), "Access denied") {
+		t.Errorf("Invalid error: %v, expected: Access denied", err)
+	}
+
+	err = conn.Raw(func(dc interface{}) error {
+		c := dc.(*Conn)
+		if err := c.ChangePassword(context.Background(), "old", "it's new"); err != nil {
+			return err
+		}
+		if c.mapi.Password != "it's new" {
+			t.Errorf("Invalid password: %s, expected: %s", c.mapi.Password, "it's new")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "SET SCHEMA sys"); err != nil {
+		t.Errorf("Session not usable after changing password: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"sALTER USER SET PASSWORD 'new' USING OLD PASSWORD 'wrong';",
+		"sALTER USER SET PASSWORD 'it\\'s new' USING OLD PASSWORD 'old';",
+		"sSET SCHEMA sys;")
+}
+
+func TestTimeZone(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "sSELECT") {
+			return "&1 0 1 1 1\n" +
+				"% .t # table_name\n" +
+				"% ts # name\n" +
+				"% timestamptz # type\n" +
+				"% 32 # length\n" +
+				"[ 2020-01-02 08:00:00.000000+00:00\t]\n"
+		}
+		return "&3\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?timezone=%2B02:00")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var ts time.Time
+	if err := db.QueryRow("SELECT ts FROM t").Scan(&ts); err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	if _, offset := ts.Zone(); offset != 7200 || ts.Hour() != 10 {
+		t.Errorf("Invalid timestamp: %v, expected: 2020-01-02 10:00:00 +0200", ts)
+	}
+
+	expectCommands(t, cmds,
+		"sSET TIME ZONE INTERVAL '+02:00' HOUR TO MINUTE;",
+		"sSELECT ts FROM t;")
+}
+
+func TestAutocommitOff(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?autocommit=false")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"Xauto_commit 0",
+		"sINSERT INTO t VALUES (1);",
+		"sCOMMIT;")
+}
+
+func TestIsValid(t *testing.T) {
+	defer checkAlways()()
+	srv := newFakeServer(t, recordCommands(make(chan string, 10), "&3\n"))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	if !conn.IsValid() {
+		t.Errorf("Open connection reported as invalid")
+	}
+	if _, err := conn.execute("SET SCHEMA sys"); err != nil {
+		t.Errorf("Connection not usable after validation: %v", err)
+	}
+
+	conn.mapi.conn.Close()
+	if conn.IsValid() {
+		t.Errorf("Closed connection reported as valid")
+	}
+}
+
+func TestIsValidServerClosed(t *testing.T) {
+	defer checkAlways()()
+	closed := make(chan struct{})
+	srv := newFakeServer(t, func(m *MapiConn) {
+		defer close(closed)
+		handshake(m)
+		// the connection is closed when the handler returns
+	})
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	<-closed
+	valid := true
+	for start := time.Now(); valid && time.Since(start) < time.Second; {
+		// give the end of the stream a moment to arrive
+		time.Sleep(10 * time.Millisecond)
+		valid = conn.IsValid()
+	}
+	if valid {
+		t.Errorf("Connection the server closed reported as valid")
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "sSELECT") {
+			return "!HYT00!Query aborted due to timeout\n"
+		}
+		return "&3\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?query_timeout=500ms")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("SELECT sys.sleep(2000)")
+	if err == nil || !strings.Contains(err.Error(), "Query aborted due to timeout") {
+		t.Errorf("Invalid error: %v, expected: Query aborted due to timeout", err)
+	}
+
+	expectCommands(t, cmds,
+		"sCALL sys.setquerytimeout(1);",
+		"sSELECT sys.sleep(2000);")
+}
+
+func TestReadOnly(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasSuffix(cmd, "INSERT INTO t VALUES (1);") {
+			return "&3\n!25006!INSERT INTO: transaction is read only\n"
+		}
+		return "&3\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?readonly=true")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// each statement is a transaction of its own in autocommit mode
+	for i := 0; i < 2; i++ {
+		_, err = db.Exec("INSERT INTO t VALUES (1)")
+		if err == nil || !strings.Contains(err.Error(), "read only") {
+			t.Errorf("Invalid error: %v, expected: transaction is read only", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	if _, err := tx.Exec("SELECT 1"); err != nil {
+		t.Errorf("Error querying: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Error committing: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"sSET TRANSACTION READ ONLY;\nINSERT INTO t VALUES (1);",
+		"sSET TRANSACTION READ ONLY;\nINSERT INTO t VALUES (1);",
+		"Xauto_commit 0",
+		"sSET TRANSACTION READ ONLY;",
+		"sSELECT 1;",
+		"sCOMMIT;",
+		"Xauto_commit 1")
+}
+
+func TestReadOnlyNoAutocommit(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&3\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?readonly=true&autocommit=false")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Error starting transaction: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("Error committing: %v", err)
+		}
+	}
+
+	expectCommands(t, cmds,
+		"Xauto_commit 0",
+		"sSET TRANSACTION READ ONLY;",
+		"sCOMMIT;",
+		"sSET TRANSACTION READ ONLY;",
+		"sCOMMIT;",
+		"sSET TRANSACTION READ ONLY;")
+}
+
+func TestMaxStatementSize(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?max_statement_size=40")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO t VALUES (1), (2), (3), (4), (5)")
+	if !errors.Is(err, ErrStatementTooLarge) || !strings.Contains(err.Error(), "COPY INTO") {
+		t.Errorf("Invalid error: %v, expected: %v", err, ErrStatementTooLarge)
+	}
+
+	expectCommands(t, cmds, "sINSERT INTO t VALUES (1);")
+	select {
+	case cmd := <-cmds:
+		t.Errorf("Statement too large sent: %s", cmd)
+	default:
+	}
+}
+
+func TestSessionSettings(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "sSET bogus") {
+			return "!42000!SET: unknown variable bogus\n"
+		}
+		return "&3\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?set.optimizer=minimal_pipe&set.max_memory=2G")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	expectCommands(t, cmds,
+		"sSET max_memory='2G';",
+		"sSET optimizer='minimal_pipe';")
+
+	db2, err := sql.Open("monetdb", srv.dsn()+"?set.bogus=1")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db2.Close()
+
+	err = db2.Ping()
+	if err == nil || !strings.Contains(err.Error(), "Setting bogus failed") {
+		t.Errorf("Invalid error: %v, expected: Setting bogus failed", err)
+	}
+
+	if _, err := parseDSN("localhost/testdb?set.a-b=1"); err == nil {
+		t.Errorf("Error parsing DSN with invalid session variable")
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		return "&1 0 1 1 1\n" +
+			"% .env # table_name\n" +
+			"% value # name\n" +
+			"% varchar # type\n" +
+			"% 8 # length\n" +
+			"[ \"11.47.11\"\t]\n"
+	}))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	for i := 0; i < 2; i++ {
+		v, err := conn.ServerVersion()
+		if err != nil {
+			t.Fatalf("Error getting server version: %v", err)
+		}
+		if v != "11.47.11" {
+			t.Errorf("Invalid server version: %s, expected: %s", v, "11.47.11")
+		}
+	}
+
+	expectCommands(t, cmds, "sSELECT value FROM sys.env() WHERE name = 'monet_version';")
+	select {
+	case cmd := <-cmds:
+		t.Errorf("Server version not cached, sent: %s", cmd)
+	default:
+	}
+}
+
+func TestMapiCommand(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, ""))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	var r string
+	err = conn.Raw(func(c interface{}) error {
+		r, err = c.(*Conn).MapiCommand(ctx, "Xreply_size 100")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error sending command: %v", err)
+	}
+	if r != "" {
+		t.Errorf("Invalid response: %q, expected: %q", r, "")
+	}
+
+	expectCommands(t, cmds, "Xreply_size 100")
+}
+
+func TestConnectRetries(t *testing.T) {
+	// find a free port, which refuses connections until the server
+	// starts listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	started := make(chan *fakeServer, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		started <- newFakeServerAt(t, addr, recordCommands(make(chan string, 10), "&3\n"))
+	}()
+	defer func() { (<-started).Close() }()
+
+	dsn := addr + "/testdb?connect_retries=5&connect_retry_interval=20ms"
+	c, err := (&Driver{}).Open(dsn)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	c.Close()
+}
+
+func TestConnectRetriesLoginFailure(t *testing.T) {
+	attempts := make(chan struct{}, 10)
+	srv := newFakeServer(t, func(m *MapiConn) {
+		attempts <- struct{}{}
+		m.putBlock([]byte(fakeChallenge))
+		m.getBlock()
+		m.putBlock([]byte("!InvalidCredentialsException:checkCredentials:invalid credentials for user 'me'\n"))
+	})
+	defer srv.Close()
+
+	_, err := (&Driver{}).Open("me:wrong@" + srv.dsn() + "?connect_retries=3&connect_retry_interval=1ms")
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Invalid error logging in with wrong password: %v, expected: %v", err, ErrAuthFailed)
+	}
+	if n := len(attempts); n != 1 {
+		t.Errorf("Invalid number of attempts: %d, expected: 1", n)
+	}
+}
+
+func TestExecInterpolated(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO t VALUES (?, '?', ?) -- ?", 1, "it's")
+	if err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	// a mismatch falls back to a prepared statement, which counts
+	// the placeholders
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?, ?)", 1, 2.5); err == nil {
+		t.Errorf("Expected error for missing argument")
+	}
+
+	expectCommands(t, cmds, "sINSERT INTO t VALUES (1, '?', 'it\\'s') -- ?;")
+	select {
+	case cmd := <-cmds:
+		t.Errorf("Statement with missing argument sent: %s", cmd)
+	default:
+	}
+}
+
+func TestManyParams(t *testing.T) {
+	const n = 500
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	query := "DELETE FROM t WHERE id IN (?" + strings.Repeat(", ?", n-1) + ")"
+	args := make([]interface{}, n)
+	values := make([]string, n)
+	for i := range args {
+		args[i] = i
+		values[i] = strconv.Itoa(i)
+	}
+	list := strings.Join(values, ", ")
+
+	start := time.Now()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("Error executing: %v", err)
+	}
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(args...); err != nil {
+		t.Fatalf("Error executing statement: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Executing with %d parameters took %v", n, d)
+	}
+
+	expectCommands(t, cmds,
+		"sDELETE FROM t WHERE id IN ("+list+");",
+		"sPREPARE "+query+";",
+		"sEXECUTE 3("+list+");")
+}
+
+func TestInterpolate(t *testing.T) {
+	type tc struct {
+		query    string
+		args     []driver.Value
+		expected string
+		ok       bool
+	}
+	var tcs = []tc{
+		tc{"SELECT ?", []driver.Value{int64(1)}, "SELECT 1", true},
+		tc{"SELECT ?, \"a?\"", []driver.Value{nil}, "SELECT NULL, \"a?\"", true},
+		tc{"SELECT 'a''?', 'b\\'?', ?", []driver.Value{"x"}, "SELECT 'a''?', 'b\\'?', 'x'", true},
+		tc{"SELECT /* ? */ ?", []driver.Value{true}, "SELECT /* ? */ true", true},
+		tc{"SELECT \"a\\\", ?", []driver.Value{int64(1)}, "SELECT \"a\\\", 1", true},
+		tc{"SELECT R'a\\', ?", []driver.Value{int64(1)}, "SELECT R'a\\', 1", true},
+		tc{"SELECT r'a\\''?', ?", []driver.Value{int64(1)}, "SELECT r'a\\''?', 1", true},
+		tc{"SELECT E'a\\'?', ?", []driver.Value{int64(1)}, "SELECT E'a\\'?', 1", true},
+		tc{"SELECT bar'a\\'?', ?", []driver.Value{int64(1)}, "SELECT bar'a\\'?', 1", true},
+		tc{"SELECT ?", nil, "", false},
+		tc{"SELECT 1", []driver.Value{int64(1)}, "", false},
+	}
+
+	for _, c := range tcs {
+		q, ok, err := interpolate(c.query, c.args)
+		if err != nil {
+			t.Errorf("Error interpolating %s: %v", c.query, err)
+		} else if q != c.expected || ok != c.ok {
+			t.Errorf("Invalid query: %s (%v), expected: %s (%v)", q, ok, c.expected, c.ok)
+		}
+	}
+}
+
+func TestExecInterpolatedConverters(t *testing.T) {
+	defer saveMappers()()
+	RegisterToMonetConverter("monetdb.cents", func(v driver.Value) (string, error) {
+		c := v.(cents)
+		return fmt.Sprintf("%d.%02d", c/100, c%100), nil
+	})
+
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	d, err := ParseDecimal("-12345678901234567.890123456")
+	if err != nil {
+		t.Fatalf("Error parsing decimal: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?, ?)", 1, d, "x"); err != nil {
+		t.Fatalf("Error inserting decimal: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?, ?)", 1, 2.5, Raw("current_timestamp")); err != nil {
+		t.Fatalf("Error inserting raw value: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?, ?)", 1, cents(1250), "x"); err != nil {
+		t.Fatalf("Error inserting registered type: %v", err)
+	}
+	expectCommands(t, cmds,
+		"sINSERT INTO t VALUES (1, -12345678901234567.890123456, 'x');",
+		"sINSERT INTO t VALUES (1, 2.5, current_timestamp);",
+		"sINSERT INTO t VALUES (1, 12.50, 'x');")
+}
+
+func benchmarkExec(b *testing.B, prepare bool) {
+	var n int64
+	srv := newFakeServer(b, serveCommands(func(cmd string) string {
+		atomic.AddInt64(&n, 1)
+		if strings.HasPrefix(cmd, "sPREPARE ") {
+			return prepareResponse
+		}
+		return "&2 1 -1\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		b.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		b.Fatalf("Error connecting: %v", err)
+	}
+
+	atomic.StoreInt64(&n, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if prepare {
+			stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+			if err != nil {
+				b.Fatalf("Error preparing statement: %v", err)
+			}
+			_, err = stmt.Exec(i, 2.5, "x")
+			stmt.Close()
+			if err != nil {
+				b.Fatalf("Error executing statement: %v", err)
+			}
+		} else {
+			if _, err := db.Exec("INSERT INTO t VALUES (?, ?, ?)", i, 2.5, "x"); err != nil {
+				b.Fatalf("Error executing statement: %v", err)
+			}
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&n))/float64(b.N), "roundtrips/op")
+}
+
+func BenchmarkExecPrepared(b *testing.B) {
+	benchmarkExec(b, true)
+}
+
+func BenchmarkExecInterpolated(b *testing.B) {
+	benchmarkExec(b, false)
+}
+
+// batchServer answers a request with one update count per EXECUTE in it,
+// and sends the requests to cmds.
+func batchServer(cmds chan<- string) func(*MapiConn) {
+	return serveCommands(func(cmd string) string {
+		if cmds != nil {
+			cmds <- cmd
+		}
+		if strings.HasPrefix(cmd, "sPREPARE ") {
+			return prepareResponse
+		}
+		if strings.Contains(cmd, "'fail'") {
+			return "&2 1 -1\n!42000!INSERT INTO: invalid value\n"
+		}
+		return strings.Repeat("&2 1 -1\n", strings.Count(cmd, "EXECUTE "))
+	})
+}
+
+func TestExecBatch(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, batchServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	var sets [][]driver.Value
+	for i := 1; i <= 5; i++ {
+		sets = append(sets, []driver.Value{int64(i), 2.5, "x"})
+	}
+	var counts []int64
+	err = conn.Raw(func(c interface{}) error {
+		counts, err = c.(*Conn).ExecBatch(ctx, "INSERT INTO t VALUES (?, ?, ?)", sets)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error executing batch: %v", err)
+	}
+	if e := []int64{1, 1, 1, 1, 1}; !reflect.DeepEqual(counts, e) {
+		t.Errorf("Invalid counts: %v, expected: %v", counts, e)
+	}
+
+	expectCommands(t, cmds,
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 3(1, 2.5, 'x');\nEXECUTE 3(2, 2.5, 'x');\nEXECUTE 3(3, 2.5, 'x');\n"+
+			"EXECUTE 3(4, 2.5, 'x');\nEXECUTE 3(5, 2.5, 'x');")
+
+	// the statement is prepared already
+	sets[1][2] = "fail"
+	err = conn.Raw(func(c interface{}) error {
+		counts, err = c.(*Conn).ExecBatch(ctx, "INSERT INTO t VALUES (?, ?, ?)", sets[:3])
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid value") {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if e := []int64{1}; !reflect.DeepEqual(counts, e) {
+		t.Errorf("Invalid counts before error: %v, expected: %v", counts, e)
+	}
+}
+
+func BenchmarkExecBatch(b *testing.B) {
+	benchmarkExecBatch(b, true)
+}
+
+func BenchmarkExecBatchLoop(b *testing.B) {
+	benchmarkExecBatch(b, false)
+}
+
+func benchmarkExecBatch(b *testing.B, batch bool) {
+	srv := newFakeServer(b, batchServer(nil))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		b.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		b.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	const query = "INSERT INTO t VALUES (?, ?, ?)"
+	sets := make([][]driver.Value, 100)
+	for i := range sets {
+		sets[i] = []driver.Value{int64(i), 2.5, "x"}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if batch {
+			err = conn.Raw(func(c interface{}) error {
+				_, err := c.(*Conn).ExecBatch(ctx, query, sets)
+				return err
+			})
+		} else {
+			var stmt *sql.Stmt
+			stmt, err = conn.PrepareContext(ctx, query)
+			for _, args := range sets {
+				if err != nil {
+					break
+				}
+				_, err = stmt.Exec(args[0], args[1], args[2])
+			}
+			if stmt != nil {
+				stmt.Close()
+			}
+		}
+		if err != nil {
+			b.Fatalf("Error executing statements: %v", err)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&4 t\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	check := func(inTx, autocommit bool) {
+		t.Helper()
+		conn.Raw(func(c interface{}) error {
+			tx, ac := c.(*Conn).Status()
+			if tx != inTx || ac != autocommit {
+				t.Errorf("Invalid status: %v, %v, expected: %v, %v", tx, ac, inTx, autocommit)
+			}
+			return nil
+		})
+	}
+
+	check(false, true)
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	check(true, true)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	check(false, true)
+
+	tx, err = conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	check(true, true)
+	tx.Rollback()
+	check(false, true)
+}
+
+func TestClose(t *testing.T) {
+	closed := make(chan error, 1)
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		// the next read ends when the client hangs up
+		_, err := m.getBlock()
+		closed <- err
+	})
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Error closing: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Error closing again: %v", err)
+	}
+
+	select {
+	case err := <-closed:
+		if err != io.EOF {
+			t.Errorf("Invalid error on the server: %v, expected: %v", err, io.EOF)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Server did not see the connection close")
+	}
+}
+
+func TestPing(t *testing.T) {
+	cmds := make(chan string, 1)
+	srv := newFakeServer(t, recordCommands(cmds, "&1 0 1 1 1\n% .t # table_name\n% single_value # name\n% tinyint # type\n% 1 # length\n[ 1\t]\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Errorf("Error pinging: %v", err)
+	}
+	expectCommands(t, cmds, "sSELECT 1;")
+}
+
+func TestChangePasswordHung(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		// read the command, but never reply
+		m.getBlock()
+		m.getBlock()
+	})
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = c.(*Conn).ChangePassword(ctx, "old", "new")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Invalid error: %v, expected: %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Changing password took %v", d)
+	}
+}
+
+func TestPingHung(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		// read the command, but never reply
+		m.getBlock()
+		m.getBlock()
+	})
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = conn.PingContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Invalid error: %v, expected: %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Ping took %v", d)
+	}
+
+	conn.Raw(func(driverConn interface{}) error {
+		if driverConn.(*Conn).IsValid() {
+			t.Errorf("Connection still valid after the ping was cancelled")
+		}
+		return nil
+	})
+	conn.Close()
+}
+
+func TestExecContextHung(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		// read the statement, but never reply
+		m.getBlock()
+		m.getBlock()
+	})
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = conn.ExecContext(ctx, "INSERT INTO t VALUES (?)", 1)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Invalid error: %v, expected: %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Exec took %v", d)
+	}
+
+	conn.Raw(func(driverConn interface{}) error {
+		if driverConn.(*Conn).IsValid() {
+			t.Errorf("Connection still valid after the statement was cancelled")
+		}
+		return nil
+	})
+	conn.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.ExecContext(cancelled, "INSERT INTO t VALUES (1)"); err != context.Canceled {
+		t.Errorf("Invalid error: %v, expected: %v", err, context.Canceled)
+	}
+}
+
+func TestUTF8RoundTrip(t *testing.T) {
+	var stored string
+	srv := newFakeServer(t, func(m *MapiConn) {
+		// split the responses within characters
+		m.BlockSize = 7
+		serveCommands(func(cmd string) string {
+			if strings.HasPrefix(cmd, "sINSERT") {
+				stored = cmd[strings.IndexByte(cmd, '\'')+1 : strings.LastIndexByte(cmd, '\'')]
+				return "&2 1 -1\n"
+			}
+			return "&1 0 1 1 1\n" +
+				"% sys.t # table_name\n" +
+				"% s # name\n" +
+				"% varchar # type\n" +
+				"% 20 # length\n" +
+				"[ \"" + stored + "\"\t]\n"
+		})(m)
+	})
+	defer srv.Close()
+
+	// the statements are split within characters as well
+	db, err := sql.Open("monetdb", srv.dsn()+"?blocksize=7")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	const e = "日本語 😀 it's\t✓ 한국어"
+	if _, err := db.Exec("INSERT INTO t VALUES (?)", e); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if s := "日本語 😀 it\\'s\\t✓ 한국어"; stored != s {
+		t.Errorf("Invalid literal sent: %q, expected: %q", stored, s)
+	}
+
+	var s string
+	if err := db.QueryRow("SELECT s FROM t").Scan(&s); err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	if s != e {
+		t.Errorf("Invalid string: %q, expected: %q", s, e)
+	}
+}
+
+// idleServer answers the first connection until it has run a query, and
+// then closes it, like a server ending an idle session. Other connections
+// are served normally.
+func idleServer(conns *int32) func(*MapiConn) {
+	return func(m *MapiConn) {
+		n := atomic.AddInt32(conns, 1)
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		for {
+			if _, err := m.getBlock(); err != nil {
+				return
+			}
+			m.putBlock([]byte("&2 1 -1\n"))
+			if n == 1 {
+				return
+			}
+		}
+	}
+}
+
+func TestIdleDisconnect(t *testing.T) {
+	defer checkAlways()()
+	var conns int32
+	srv := newFakeServer(t, idleServer(&conns))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatalf("Error deleting after the server closed the connection: %v", err)
+	}
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Errorf("Invalid number of connections: %d, expected: 2", n)
+	}
+}
+
+func TestIdleDisconnectPinned(t *testing.T) {
+	defer checkAlways()()
+	var conns int32
+	srv := newFakeServer(t, idleServer(&conns))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "DELETE FROM t"); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_, err = conn.ExecContext(context.Background(), "DELETE FROM t")
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("Invalid error: %v, expected: %v", err, driver.ErrBadConn)
+	}
+}
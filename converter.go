@@ -6,10 +6,17 @@ package monetdb
 
 import (
 	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 )
@@ -23,7 +30,7 @@ const (
 	mdb_SMALLINT  = "smallint" // 16 bit integer
 	mdb_INT       = "int"      // 32 bit integer
 	mdb_BIGINT    = "bigint"   // 64 bit integer
-	mdb_HUGEINT   = "hugeint"  // 64 bit integer
+	mdb_HUGEINT   = "hugeint"  // 128 bit integer
 	mdb_SERIAL    = "serial"   // special 64 bit integer sequence generator
 	mdb_REAL      = "real"     // 32 bit floating point
 	mdb_DOUBLE    = "double"   // 64 bit floating point
@@ -33,9 +40,13 @@ const (
 	mdb_TIMESTAMP = "timestamp" // (T) date concatenated with unique time
 	mdb_INTERVAL  = "interval"  // (Q) a temporal interval
 	mdb_UUID      = "uuid"
+	mdb_OID       = "oid" // object identifier, e.g. 42@0
+	mdb_JSON      = "json"
 
 	mdb_MONTH_INTERVAL = "month_interval"
 	mdb_SEC_INTERVAL   = "sec_interval"
+	mdb_DAY_INTERVAL   = "day_interval"
+	mdb_HOUR_INTERVAL  = "hour_interval"
 	mdb_WRD            = "wrd"
 	mdb_TINYINT        = "tinyint"
 
@@ -45,21 +56,92 @@ const (
 	mdb_LONGINT     = "longint"
 	mdb_FLOAT       = "float"
 	mdb_TIMESTAMPTZ = "timestamptz"
-
-	// full names and aliases, spaces are replaced with underscores
-	mdb_CHARACTER               = mdb_CHAR
-	mdb_CHARACTER_VARYING       = mdb_VARCHAR
-	mdb_CHARACHTER_LARGE_OBJECT = mdb_CLOB
-	mdb_BINARY_LARGE_OBJECT     = mdb_BLOB
-	mdb_NUMERIC                 = mdb_DECIMAL
-	mdb_DOUBLE_PRECISION        = mdb_DOUBLE
+	mdb_TIMETZ      = "timetz"
 )
 
+// typeAliases maps full type names and aliases, with spaces replaced by
+// underscores, to the names the converters are registered under.
+var typeAliases = map[string]string{
+	"character":              mdb_CHAR,
+	"character_varying":      mdb_VARCHAR,
+	"character_large_object": mdb_CLOB,
+	"binary_large_object":    mdb_BLOB,
+	"numeric":                mdb_DECIMAL,
+	"double_precision":       mdb_DOUBLE,
+	"integer":                mdb_INT,
+
+	// the names of the MAL types, which some aggregates and window
+	// functions report
+	"bit": mdb_BOOLEAN,
+	"bte": mdb_TINYINT,
+	"sht": mdb_SMALLINT,
+	"lng": mdb_BIGINT,
+	"hge": mdb_HUGEINT,
+	"flt": mdb_REAL,
+	"dbl": mdb_DOUBLE,
+	"str": mdb_CLOB,
+}
+
+// baseType returns the name the converter for a type is registered under.
+// Parameters such as the precision in decimal(10,2) are left out, and so
+// are subtypes, as in json:object. A json subtype of another type, as in
+// varchar(json) or varchar:json, makes it json.
+func baseType(t string) string {
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		if isJSONSubtype(strings.TrimSuffix(t[i+1:], ")")) {
+			return mdb_JSON
+		}
+		t = strings.TrimSpace(t[:i])
+	}
+	if i := strings.IndexByte(t, ':'); i >= 0 {
+		if isJSONSubtype(t[i+1:]) {
+			return mdb_JSON
+		}
+		t = strings.TrimSpace(t[:i])
+	}
+	t = strings.ToLower(strings.Replace(t, " ", "_", -1))
+	if a, ok := typeAliases[t]; ok {
+		return a
+	}
+	return t
+}
+
+// typeParams returns the precision and scale of a type such as
+// decimal(38,2), or false if it has none.
+func typeParams(t string) (int, int, bool) {
+	i := strings.IndexByte(t, '(')
+	if i < 0 || !strings.HasSuffix(t, ")") {
+		return 0, 0, false
+	}
+	params := strings.Split(t[i+1:len(t)-1], ",")
+	precision, err := strconv.Atoi(strings.TrimSpace(params[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	scale := 0
+	if len(params) > 1 {
+		if scale, err = strconv.Atoi(strings.TrimSpace(params[1])); err != nil {
+			return 0, 0, false
+		}
+	}
+	return precision, scale, true
+}
+
+func isJSONSubtype(s string) bool {
+	return strings.EqualFold(strings.TrimSpace(s), mdb_JSON)
+}
+
+// mdb_NULL is the unquoted token MonetDB sends for a NULL cell.
+const mdb_NULL = "NULL"
+
 var timeFormats = []string{
 	"2006-01-02",
 	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05-07:00",
 	"2006-01-02 15:04:05 -0700",
 	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02T15:04:05", // ISO 8601
+	"2006-01-02T15:04:05Z07:00",
 	"Mon Jan 2 15:04:05 -0700 MST 2006",
 	"15:04:05",
 }
@@ -68,38 +150,46 @@ type toGoConverter func(string) (driver.Value, error)
 type toMonetConverter func(driver.Value) (string, error)
 
 func stripNoQuote(v string) (driver.Value, error) {
-	return unquote(strings.TrimSpace(v[0 : len(v)]))
+	return unquote(v)
 }
 
+// strip removes the quotes around a string value. Blanks inside the
+// quotes are part of the value and kept.
 func strip(v string) (driver.Value, error) {
-	return unquote(strings.TrimSpace(v[1 : len(v)-1]))
-}
-
-// from strconv.contains
-// contains reports whether the string contains the byte c.
-func contains(s string, c byte) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i] == c {
-			return true
-		}
+	if len(v) < 2 {
+		return nil, fmt.Errorf("Invalid string value: %q", v)
 	}
-	return false
+	return unquote(v[1 : len(v)-1])
 }
 
-// adapted from strconv.Unquote
+// unquote resolves the backslash escapes in s. It is adapted from
+// strconv.Unquote, but copies the text between escapes in bulk, so large
+// values are scanned only once.
 func unquote(s string) (string, error) {
-	// Is it trivial?  Avoid allocation.
-	if !contains(s, '\\') {
+	i := strings.IndexByte(s, '\\')
+	if i < 0 {
+		// Is it trivial?  Avoid allocation.
 		return s, nil
 	}
 
 	var runeTmp [utf8.UTFMax]byte
-	buf := make([]byte, 0, 3*len(s)/2) // Try to avoid more allocations.
-	for len(s) > 0 {
+	size := len(s)
+	buf := make([]byte, 0, len(s))
+	for i >= 0 {
+		buf = append(buf, s[:i]...)
+		s = s[i:]
+
+		// values may be quoted with either kind of quote
+		if len(s) > 1 && (s[1] == '"' || s[1] == '\'') {
+			buf = append(buf, s[1])
+			s = s[2:]
+			i = strings.IndexByte(s, '\\')
+			continue
+		}
+
 		c, multibyte, ss, err := strconv.UnquoteChar(s, '\'')
 		if err != nil {
-			fmt.Printf("E: %v\n -> %s\n", err, s)
-			return "", err
+			return "", fmt.Errorf("Invalid escape sequence at position %d: %w", size-len(s), err)
 		}
 		s = ss
 		if c < utf8.RuneSelf || !multibyte {
@@ -108,66 +198,271 @@ func unquote(s string) (string, error) {
 			n := utf8.EncodeRune(runeTmp[:], c)
 			buf = append(buf, runeTmp[:n]...)
 		}
+		i = strings.IndexByte(s, '\\')
 	}
+	buf = append(buf, s...)
 	return string(buf), nil
 }
 
+// toDuration converts a second interval. That is a number of seconds
+// such as 90.500, or a time of day, optionally preceded by a number of
+// days, such as 04:05:06 or 3 days, 04:05:06.
+func toDuration(v string) (driver.Value, error) {
+	d, err := parseDuration(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid interval value: %s", v)
+	}
+	return d, nil
+}
+
+func parseDuration(v string) (time.Duration, error) {
+	if !strings.Contains(v, ":") {
+		return time.ParseDuration(v + "s")
+	}
+
+	neg := strings.HasPrefix(v, "-")
+	v = strings.TrimPrefix(v, "-")
+
+	var d time.Duration
+	if i := strings.IndexByte(v, ' '); i >= 0 {
+		days, err := strconv.Atoi(v[:i])
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(days) * 24 * time.Hour
+		v = strings.TrimSpace(v[i+1:])
+		for _, unit := range []string{"days,", "day,", "days", "day"} {
+			if strings.HasPrefix(v, unit) {
+				v = strings.TrimSpace(v[len(unit):])
+				break
+			}
+		}
+	}
+
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("Invalid time: %s", v)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	sec, err := time.ParseDuration(parts[2] + "s")
+	if err != nil || sec < 0 {
+		return 0, fmt.Errorf("Invalid seconds: %s", parts[2])
+	}
+	d += time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + sec
+
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// toMonthInterval converts a month interval, a number of months such as
+// 18, or years and months such as 1-06, to an Interval.
+func toMonthInterval(v string) (driver.Value, error) {
+	neg := strings.HasPrefix(v, "-")
+	s := strings.TrimPrefix(v, "-")
+
+	var months int64
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		y, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid interval value: %s", v)
+		}
+		m, err := strconv.ParseInt(s[i+1:], 10, 64)
+		if err != nil || m < 0 || m > 11 {
+			return nil, fmt.Errorf("Invalid interval value: %s", v)
+		}
+		months = y*12 + m
+	} else {
+		m, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || m < 0 {
+			return nil, fmt.Errorf("Invalid interval value: %s", v)
+		}
+		months = m
+	}
+
+	if neg {
+		months = -months
+	}
+	return Interval{Months: months}, nil
+}
+
+// toJSON converts a json value. Values extracted from a json document
+// may be scalars that are not quoted.
+func toJSON(v string) (driver.Value, error) {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return strip(v)
+	}
+	return v, nil
+}
+
+// toByteArray converts a blob, which is either quoted raw bytes, hex text,
+// optionally with a 0x prefix, or hex text prefixed with its length in
+// bytes, as in "4:DEADBEEF". An empty blob converts to an empty, non-nil
+// slice, to tell it apart from NULL.
 func toByteArray(v string) (driver.Value, error) {
-	return []byte(v[1 : len(v)-1]), nil
+	if v == "" {
+		return []byte{}, nil
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return []byte(v[1 : len(v)-1]), nil
+	}
+
+	size := -1
+	if i := strings.IndexByte(v, ':'); i > 0 {
+		n, err := strconv.Atoi(v[:i])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid blob length: %s", v[:i])
+		}
+		size = n
+		v = v[i+1:]
+	} else if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+		v = v[2:]
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid blob value: %v", err)
+	}
+	if size >= 0 && len(b) != size {
+		return nil, fmt.Errorf("Invalid blob value: %d bytes, expected: %d", len(b), size)
+	}
+	return b, nil
 }
 
+// ErrEmptyNumeric is returned for an empty value of a numeric type, which
+// the server sends as NULL instead.
+var ErrEmptyNumeric = errors.New("Empty numeric value")
+
 func toDouble(v string) (driver.Value, error) {
+	if v == "" {
+		return nil, fmt.Errorf("%w for float64", ErrEmptyNumeric)
+	}
 	return strconv.ParseFloat(v, 64)
 }
 
+// toDecimal converts a decimal to a float64. A decimal that is zero, but
+// written with a minus, as in -0.00, is returned as 0 rather than -0, as
+// decimals have no negative zero.
+func toDecimal(v string) (driver.Value, error) {
+	f, err := toDouble(v)
+	if err != nil {
+		return nil, err
+	}
+	if f == 0.0 {
+		return 0.0, nil
+	}
+	return f, nil
+}
+
+// toFloat converts a real, which may be in scientific notation. A value
+// beyond the range of a float32 is an error rather than an infinity.
 func toFloat(v string) (driver.Value, error) {
-	var r float32
+	if v == "" {
+		return nil, fmt.Errorf("%w for float32", ErrEmptyNumeric)
+	}
 	i, err := strconv.ParseFloat(v, 32)
-	if err == nil {
-		r = float32(i)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("Real value out of range for float32: %s", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return float32(i), nil
+}
+
+// parseInt parses an integer that fits in the Go type typeName of the
+// given size in bits. A fractional part of only zeros, as in "42.0", is
+// accepted, as computed columns may have one.
+func parseInt(v string, bitSize int, typeName string) (int64, error) {
+	if v == "" {
+		return 0, fmt.Errorf("%w for %s", ErrEmptyNumeric, typeName)
+	}
+	s := v
+	if i := strings.IndexByte(s, '.'); i > 0 && strings.Trim(s[i+1:], "0") == "" {
+		s = s[:i]
 	}
-	return r, err
+	i, err := strconv.ParseInt(s, 10, bitSize)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok {
+			err = ne.Err
+		}
+		return 0, fmt.Errorf("Invalid value for %s: %s: %w", typeName, v, err)
+	}
+	return i, nil
 }
 
 func toInt8(v string) (driver.Value, error) {
-	var r int8
-	i, err := strconv.ParseInt(v, 10, 8)
-	if err == nil {
-		r = int8(i)
+	i, err := parseInt(v, 8, "int8")
+	if err != nil {
+		return nil, err
 	}
-	return r, err
+	return int8(i), nil
 }
 
 func toInt16(v string) (driver.Value, error) {
-	var r int16
-	i, err := strconv.ParseInt(v, 10, 16)
-	if err == nil {
-		r = int16(i)
+	i, err := parseInt(v, 16, "int16")
+	if err != nil {
+		return nil, err
 	}
-	return r, err
+	return int16(i), nil
 }
 
 func toInt32(v string) (driver.Value, error) {
-	var r int32
-	i, err := strconv.ParseInt(v, 10, 32)
-	if err == nil {
-		r = int32(i)
+	i, err := parseInt(v, 32, "int32")
+	if err != nil {
+		return nil, err
 	}
-	return r, err
+	return int32(i), nil
 }
 
 func toInt64(v string) (driver.Value, error) {
-	var r int64
+	i, err := parseInt(v, 64, "int64")
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// toHugeInt converts a hugeint, which is 128 bits wide. A value that
+// does not fit in an int64 is an error, it is not clamped.
+func toHugeInt(v string) (driver.Value, error) {
+	if v == "" {
+		return nil, fmt.Errorf("%w for hugeint", ErrEmptyNumeric)
+	}
 	i, err := strconv.ParseInt(v, 10, 64)
-	if err == nil {
-		r = int64(i)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("Hugeint value out of range for int64: %s", v)
 	}
-	return r, err
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
 }
 
+// yearRe matches the year of a date. MonetDB does not pad years to four
+// digits, and years before the common era are negative.
+var yearRe = regexp.MustCompile(`^(-?)(\d+)-`)
+
 func parseTime(v string) (t time.Time, err error) {
+	return parseTimeIn(v, time.UTC)
+}
+
+// parseTimeIn is like parseTime, but a value without a UTC offset is
+// taken to be in the given location.
+func parseTimeIn(v string, loc *time.Location) (t time.Time, err error) {
+	if m := yearRe.FindStringSubmatch(v); m != nil && (m[1] != "" || len(m[2]) != 4) {
+		return parseYear(m[1] != "", m[2], v[len(m[0]):], loc)
+	}
+
 	for _, f := range timeFormats {
-		t, err = time.Parse(f, v)
+		t, err = time.ParseInLocation(f, v, loc)
 		if err == nil {
 			return
 		}
@@ -175,8 +470,48 @@ func parseTime(v string) (t time.Time, err error) {
 	return
 }
 
+// parseYear parses a date or timestamp whose year does not have the four
+// digits time.Parse expects. The rest of the value is parsed with the year
+// padded, after which the actual year is set.
+func parseYear(negative bool, year, rest string, loc *time.Location) (time.Time, error) {
+	y, err := strconv.Atoi(year)
+	if err != nil || y > 9999 {
+		return time.Time{}, fmt.Errorf("Invalid year: %s", year)
+	}
+
+	t, err := parseTimeIn(fmt.Sprintf("%04d-%s", y, rest), loc)
+	if err != nil {
+		return t, err
+	}
+	if negative {
+		y = -y
+	}
+	return time.Date(y, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond(), t.Location()), nil
+}
+
+// toOID converts an oid, which may have a "@0" suffix.
+func toOID(v string) (driver.Value, error) {
+	if i := strings.IndexByte(v, '@'); i >= 0 {
+		v = v[:i]
+	}
+	o, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid oid value: %s", v)
+	}
+	return OID(o), nil
+}
+
+// toBool converts a boolean, which is spelled out or given as a 1 or 0,
+// as some result modes do.
 func toBool(v string) (driver.Value, error) {
-	return strconv.ParseBool(v)
+	switch strings.ToLower(v) {
+	case "true", "t", "1":
+		return true, nil
+	case "false", "f", "0":
+		return false, nil
+	}
+	return nil, fmt.Errorf("Invalid boolean value: %s", v)
 }
 
 func toDate(v string) (driver.Value, error) {
@@ -196,6 +531,18 @@ func toTime(v string) (driver.Value, error) {
 	hour, min, sec := t.Clock()
 	return Time{hour, min, sec}, nil
 }
+
+// toTimeTZ converts a time with time zone, such as 12:34:56+02:00.
+func toTimeTZ(v string) (driver.Value, error) {
+	t, err := time.Parse("15:04:05Z07:00", v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timetz value: %s", v)
+	}
+	hour, min, sec := t.Clock()
+	_, offset := t.Zone()
+	return TimeTZ{Time{hour, min, sec}, offset}, nil
+}
+
 func toTimestamp(v string) (driver.Value, error) {
 	return parseTime(v)
 }
@@ -208,12 +555,12 @@ var toGoMappers = map[string]toGoConverter{
 	mdb_VARCHAR:        strip,
 	mdb_CLOB:           strip,
 	mdb_BLOB:           toByteArray,
-	mdb_DECIMAL:        toDouble,
+	mdb_DECIMAL:        toDecimal,
 	mdb_SMALLINT:       toInt16,
 	mdb_INT:            toInt32,
-	mdb_WRD:            toInt32,
+	mdb_WRD:            toInt64, // 64 bits wide on 64-bit servers
 	mdb_BIGINT:         toInt64,
-	mdb_HUGEINT:        toInt64,
+	mdb_HUGEINT:        toHugeInt,
 	mdb_SERIAL:         toInt64,
 	mdb_REAL:           toFloat,
 	mdb_DOUBLE:         toDouble,
@@ -222,45 +569,212 @@ var toGoMappers = map[string]toGoConverter{
 	mdb_TIME:           toTime,
 	mdb_TIMESTAMP:      toTimestamp,
 	mdb_TIMESTAMPTZ:    toTimestampTz,
+	mdb_TIMETZ:         toTimeTZ,
 	mdb_INTERVAL:       strip,
-	mdb_MONTH_INTERVAL: strip,
-	mdb_SEC_INTERVAL:   strip,
+	mdb_MONTH_INTERVAL: toMonthInterval,
+	mdb_SEC_INTERVAL:   toDuration,
+	mdb_DAY_INTERVAL:   stripNoQuote,
+	mdb_HOUR_INTERVAL:  stripNoQuote,
 	mdb_TINYINT:        toInt8,
 	mdb_SHORTINT:       toInt16,
 	mdb_MEDIUMINT:      toInt32,
 	mdb_LONGINT:        toInt64,
 	mdb_FLOAT:          toFloat,
 	mdb_UUID:           stripNoQuote,
+	mdb_OID:            toOID,
+	mdb_JSON:           toJSON,
 }
 
 func toString(v driver.Value) (string, error) {
 	return fmt.Sprintf("%v", v), nil
 }
 
+// quoteReplacer escapes the characters that cannot appear as is in a
+// string literal, or would break the line based protocol.
+var quoteReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+)
+
 func toQuotedString(v driver.Value) (string, error) {
 	s := fmt.Sprintf("%v", v)
-	s = strings.Replace(s, "\\", "\\\\", -1)
-	s = strings.Replace(s, "'", "\\'", -1)
-	return fmt.Sprintf("'%v'", s), nil
+	return "'" + quoteReplacer.Replace(s) + "'", nil
+}
+
+// QuoteIdentifier quotes a table, column or other name for use in an SQL
+// statement, e.g. QuoteIdentifier(`my "table"`) returns `"my ""table"""`.
+// A quoted name is case sensitive and may be a reserved word. Names
+// containing a NUL byte are rejected.
+func QuoteIdentifier(name string) (string, error) {
+	if strings.IndexByte(name, 0) >= 0 {
+		return "", fmt.Errorf("Invalid identifier: %q", name)
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`, nil
+}
+
+// Literal returns v as an SQL literal, escaped and formatted the way the
+// driver sends arguments, e.g. Literal("it's") returns 'it\'s' and
+// Literal(nil) returns NULL. It is meant for code that builds statements,
+// such as a bulk INSERT, itself.
+func Literal(v driver.Value) (string, error) {
+	return convertToMonet(v)
+}
+
+// numericRe matches a numeric literal.
+var numericRe = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+func toNumber(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case json.Number:
+		if !numericRe.MatchString(string(val)) {
+			return "", fmt.Errorf("Invalid number: %q", string(val))
+		}
+		return string(val), nil
+	case Numeric:
+		if !numericRe.MatchString(string(val)) {
+			return "", fmt.Errorf("Invalid number: %q", string(val))
+		}
+		return string(val), nil
+	case *big.Float:
+		if val == nil {
+			return toNull(v)
+		}
+		if val.IsInf() {
+			return "", fmt.Errorf("Invalid number: %v", val)
+		}
+		return val.Text('f', -1), nil
+	case *big.Int:
+		if val == nil {
+			return toNull(v)
+		}
+		return val.String(), nil
+	case Decimal:
+		return val.String(), nil
+	default:
+		return "", fmt.Errorf("Unsupported type")
+	}
+}
+
+// toIntervalString converts a time.Duration to a second interval, and an
+// Interval to a month or a second interval. An Interval with both months
+// and a duration cannot be written, as MonetDB has no interval type that
+// holds both.
+func toIntervalString(v driver.Value) (string, error) {
+	if i, ok := v.(Interval); ok {
+		switch {
+		case i.Months != 0 && i.Duration != 0:
+			return "", fmt.Errorf("Invalid interval: %v, an interval has either months or a duration", i)
+		case i.Months != 0:
+			return fmt.Sprintf("INTERVAL '%d' MONTH", i.Months), nil
+		}
+		return toIntervalString(i.Duration)
+	}
+
+	d := v.(time.Duration)
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	s := strconv.FormatInt(int64(d/time.Second), 10)
+	if f := d % time.Second; f != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%09d", f), "0")
+	}
+	return fmt.Sprintf("INTERVAL '%s%s' SECOND", sign, s), nil
+}
+
+// toOIDString sends an oid as a plain number, as SQL does not accept the
+// "@0" suffix the server adds to oid values.
+func toOIDString(v driver.Value) (string, error) {
+	return strconv.FormatUint(uint64(v.(OID)), 10), nil
+}
+
+func toRaw(v driver.Value) (string, error) {
+	return string(v.(Raw)), nil
+}
+
+func toBoolString(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case bool:
+		if val {
+			return "true", nil
+		}
+		return "false", nil
+	case *bool:
+		if val == nil {
+			return toNull(v)
+		}
+		return toBoolString(*val)
+	case BoolAsInt:
+		if val {
+			return "1", nil
+		}
+		return "0", nil
+	default:
+		return "", fmt.Errorf("Unsupported type")
+	}
 }
 
 func toNull(v driver.Value) (string, error) {
 	return "NULL", nil
 }
 
+// toByteString converts a byte slice to a blob literal such as
+// blob 'DEADBEEF', which is NULL if the slice is nil and an empty blob if
+// it is empty.
 func toByteString(v driver.Value) (string, error) {
 	switch val := v.(type) {
 	case []uint8:
-		return toQuotedString(string(val))
+		if val == nil {
+			return toNull(nil)
+		}
+		return "blob '" + strings.ToUpper(hex.EncodeToString(val)) + "'", nil
 	default:
 		return "", fmt.Errorf("Unsupported type")
 	}
 }
 
+// toJSONString converts a json.RawMessage to a json literal. It has the
+// same underlying type as a []byte, but holds text, not a blob.
+func toJSONString(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case json.RawMessage:
+		if val == nil {
+			return toNull(nil)
+		}
+		return "json '" + quoteReplacer.Replace(string(val)) + "'", nil
+	default:
+		return "", fmt.Errorf("Unsupported type")
+	}
+}
+
+const (
+	timestampFormat   = "2006-01-02 15:04:05.999999"
+	timestampTzFormat = "2006-01-02 15:04:05.999999-07:00"
+)
+
 func toDateTimeString(v driver.Value) (string, error) {
 	switch val := v.(type) {
+	case time.Time:
+		return toQuotedString(val.Format(timestampFormat))
+	case TimestampTZ:
+		return toQuotedString(val.Format(timestampTzFormat))
+	case Timestamp:
+		if val.Precision < 0 || val.Precision > 6 {
+			return "", fmt.Errorf("Invalid timestamp precision: %d", val.Precision)
+		}
+		layout := timestampFormat[:len("2006-01-02 15:04:05")]
+		if val.Precision > 0 {
+			layout += "." + strings.Repeat("0", val.Precision)
+		}
+		return toQuotedString(val.Time.Format(layout))
 	case Time:
 		return toQuotedString(fmt.Sprintf("%02d:%02d:%02d", val.Hour, val.Min, val.Sec))
+	case TimeTZ:
+		return toQuotedString(val.String())
 	case Date:
 		return toQuotedString(fmt.Sprintf("%04d-%02d-%02d", val.Year, val.Month, val.Day))
 	default:
@@ -269,40 +783,342 @@ func toDateTimeString(v driver.Value) (string, error) {
 }
 
 var toMonetMappers = map[string]toMonetConverter{
-	"int":          toString,
-	"int8":         toString,
-	"int16":        toString,
-	"int32":        toString,
-	"int64":        toString,
-	"float":        toString,
-	"float32":      toString,
-	"float64":      toString,
-	"bool":         toString,
-	"string":       toQuotedString,
-	"nil":          toNull,
-	"[]uint8":      toByteString,
-	"time.Time":    toQuotedString,
-	"monetdb.Time": toDateTimeString,
-	"monetdb.Date": toDateTimeString,
+	"int":                 toString,
+	"int8":                toString,
+	"int16":               toString,
+	"int32":               toString,
+	"int64":               toString,
+	"float":               toString,
+	"float32":             toString,
+	"float64":             toString,
+	"bool":                toBoolString,
+	"*bool":               toBoolString,
+	"monetdb.BoolAsInt":   toBoolString,
+	"string":              toQuotedString,
+	"nil":                 toNull,
+	"[]uint8":             toByteString,
+	"time.Time":           toDateTimeString,
+	"monetdb.Time":        toDateTimeString,
+	"monetdb.TimeTZ":      toDateTimeString,
+	"monetdb.Date":        toDateTimeString,
+	"monetdb.TimestampTZ": toDateTimeString,
+	"monetdb.Timestamp":   toDateTimeString,
+	"json.Number":         toNumber,
+	"json.RawMessage":     toJSONString,
+	"jsontext.Value":      toJSONString, // json.RawMessage, where it is an alias
+	"*big.Float":          toNumber,
+	"*big.Int":            toNumber,
+	"monetdb.Decimal":     toNumber,
+	"monetdb.Numeric":     toNumber,
+	"time.Duration":       toIntervalString,
+	"monetdb.Interval":    toIntervalString,
+	"monetdb.OID":         toOIDString,
+	"monetdb.Raw":         toRaw,
+}
+
+// toMonetParamMappers holds converters for prepared statement parameters
+// of a known MonetDB type. They handle the Go types database/sql commonly
+// passes for such a parameter without reflection, and fall back to
+// convertToMonet for anything else. Numbers given as strings are sent
+// unquoted, so the server does not have to cast them.
+var toMonetParamMappers = map[string]toMonetConverter{
+	mdb_TINYINT:  toIntParam,
+	mdb_SMALLINT: toIntParam,
+	mdb_INT:      toIntParam,
+	mdb_WRD:      toIntParam,
+	mdb_BIGINT:   toIntParam,
+	mdb_HUGEINT:  toIntParam,
+	mdb_SERIAL:   toIntParam,
+	mdb_REAL:     toFloatParam,
+	mdb_FLOAT:    toFloatParam,
+	mdb_DOUBLE:   toFloatParam,
+	mdb_DECIMAL:  toDecimalParam,
+	mdb_CHAR:     toStringParam,
+	mdb_VARCHAR:  toStringParam,
+	mdb_CLOB:     toStringParam,
+	mdb_BOOLEAN:  toBoolParam,
+}
+
+// integerParam matches the strings accepted for an integer parameter.
+var integerParam = regexp.MustCompile(`^\s*[-+]?\d+\s*$`)
+
+func toIntParam(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case bool:
+		// a bool is stored as a flag
+		if val {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		if !integerParam.MatchString(val) {
+			return "", fmt.Errorf("Not an integer: %q", val)
+		}
+		return strings.TrimSpace(val), nil
+	}
+	return convertToMonet(v)
+}
+
+func toFloatParam(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case string:
+		s := strings.TrimSpace(val)
+		if numericRe.MatchString(s) {
+			return s, nil
+		}
+		// NaN and Inf are only understood as strings
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "", fmt.Errorf("Not a number: %q", val)
+		}
+	}
+	return convertToMonet(v)
+}
+
+// toDecimalParam converts a decimal parameter, writing floats without an
+// exponent.
+func toDecimalParam(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return "", fmt.Errorf("Not a number: %v", val)
+		}
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case string:
+		s := strings.TrimSpace(val)
+		if !numericRe.MatchString(s) {
+			return "", fmt.Errorf("Not a number: %q", val)
+		}
+		return s, nil
+	}
+	return convertToMonet(v)
+}
+
+// toBoolParam converts a boolean parameter. Integers are accepted as
+// flags, with any non-zero value being true.
+func toBoolParam(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case int64:
+		return toBoolString(val != 0)
+	case string:
+		b, err := toBool(strings.TrimSpace(val))
+		if err != nil {
+			return "", err
+		}
+		return toBoolString(b)
+	}
+	return convertToMonet(v)
+}
+
+func toStringParam(v driver.Value) (string, error) {
+	if val, ok := v.(string); ok {
+		return toQuotedString(val)
+	}
+	return convertToMonet(v)
+}
+
+// connToGoMappers returns the converters a connection uses instead of the
+// default ones, as selected by its configuration.
+func connToGoMappers(c Config) map[string]toGoConverter {
+	m := make(map[string]toGoConverter)
+	if c.RawStrings {
+		m[mdb_CHAR] = stripRaw
+		m[mdb_VARCHAR] = stripRaw
+		m[mdb_CLOB] = stripRaw
+	}
+	if c.TrimChar {
+		m[mdb_CHAR] = stripPadding
+	}
+	if c.TimeZone != nil {
+		m[mdb_TIMESTAMPTZ] = toTimestampTzIn(c.TimeZone)
+	}
+	if c.Location != nil {
+		m[mdb_TIMESTAMP] = toTimestampIn(c.Location)
+	}
+	if c.ForceUTC {
+		m[mdb_TIMESTAMPTZ] = toTimestampTzIn(time.UTC)
+		m[mdb_TIMESTAMP] = toTimestampIn(time.UTC)
+	}
+	if c.ExactDecimals {
+		m[mdb_DECIMAL] = toExactDecimal
+	}
+	return m
+}
+
+// toExactDecimal converts a decimal to its text, such as "12.50", which
+// can be scanned into a Decimal without losing digits. Like toDecimal, it
+// drops the minus of a zero, as in -0.00.
+func toExactDecimal(v string) (driver.Value, error) {
+	d, err := ParseDecimal(v)
+	if err != nil {
+		return nil, err
+	}
+	if d.Unscaled.Sign() == 0 {
+		v = strings.TrimPrefix(v, "-")
+	}
+	return v, nil
+}
+
+// toTimestampTzIn returns a converter for timestamps with time zone that
+// returns them in the given location.
+func toTimestampTzIn(loc *time.Location) toGoConverter {
+	return func(v string) (driver.Value, error) {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.In(loc), nil
+	}
+}
+
+// toTimestampIn returns a converter for timestamps without time zone that
+// takes them to be in the given location.
+func toTimestampIn(loc *time.Location) toGoConverter {
+	return func(v string) (driver.Value, error) {
+		return parseTimeIn(v, loc)
+	}
+}
+
+// toRawValue returns a value of any type as the text the server sent. Only
+// strings are unquoted, and NULL is nil.
+func toRawValue(v string) (driver.Value, error) {
+	v = strings.TrimSpace(v)
+	if v == mdb_NULL {
+		return nil, nil
+	}
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return strip(v)
+	}
+	return v, nil
+}
+
+// stripRaw removes the quotes around a string value, but leaves its
+// escape sequences as they are.
+func stripRaw(v string) (driver.Value, error) {
+	if len(v) < 2 {
+		return nil, fmt.Errorf("Invalid string value: %q", v)
+	}
+	return []byte(v[1 : len(v)-1]), nil
+}
+
+// stripPadding is like strip, but also removes the blanks a CHAR(n)
+// value is padded with.
+func stripPadding(v string) (driver.Value, error) {
+	s, err := strip(v)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimRight(s.(string), " "), nil
+}
+
+// mappersMu guards toGoMappers and toMonetMappers, which converters can
+// be registered in at any time.
+var mappersMu sync.RWMutex
+
+// RegisterToGoConverter registers the function converting values of the
+// MonetDB type dbType, as named in result sets, to Go values. It replaces
+// the converter of a type the driver supports. The function is not called
+// for NULL values.
+func RegisterToGoConverter(dbType string, fn func(string) (driver.Value, error)) {
+	mappersMu.Lock()
+	defer mappersMu.Unlock()
+	toGoMappers[dbType] = fn
+}
+
+// RegisterToMonetConverter registers the function converting arguments of
+// the Go type typeName, as printed by reflect, e.g. "money.Amount", to an
+// SQL literal. Arguments of the type are no longer converted by
+// database/sql, so the function also handles a type implementing
+// driver.Valuer.
+func RegisterToMonetConverter(typeName string, fn func(driver.Value) (string, error)) {
+	mappersMu.Lock()
+	defer mappersMu.Unlock()
+	toMonetMappers[typeName] = fn
 }
 
 func convertToGo(value, dataType string) (driver.Value, error) {
-	if mapper, ok := toGoMappers[dataType]; ok {
+	return convertToGoWith(nil, value, dataType)
+}
+
+// convertToGoWith is like convertToGo, but prefers the converters in
+// mappers over the default ones.
+func convertToGoWith(mappers map[string]toGoConverter, value, dataType string) (driver.Value, error) {
+	mapper, ok := lookupToGoMapper(mappers, dataType)
+	if ok {
 		value := strings.TrimSpace(value)
+		if value == mdb_NULL {
+			return nil, nil
+		}
 		return mapper(value)
 	}
 	return nil, fmt.Errorf("Type not supported: %s", dataType)
 }
 
-func convertToMonet(value driver.Value) (string, error) {
+// lookupToGoMapper is like toGoMapper, but falls back to the converter of
+// the base type, e.g. varchar for "character varying(10)".
+func lookupToGoMapper(mappers map[string]toGoConverter, dataType string) (toGoConverter, bool) {
+	mapper, ok := toGoMapper(mappers, dataType)
+	if !ok {
+		if t := baseType(dataType); t != dataType {
+			mapper, ok = toGoMapper(mappers, t)
+		}
+	}
+	return mapper, ok
+}
+
+// toGoMapper returns the converter for dataType, preferring the one in
+// mappers.
+func toGoMapper(mappers map[string]toGoConverter, dataType string) (toGoConverter, bool) {
+	if mapper, ok := mappers[dataType]; ok {
+		return mapper, true
+	}
+	mappersMu.RLock()
+	mapper, ok := toGoMappers[dataType]
+	mappersMu.RUnlock()
+	return mapper, ok
+}
+
+// toMonetMapper returns the converter registered for the type of value.
+func toMonetMapper(value driver.Value) (toMonetConverter, bool) {
 	t := reflect.TypeOf(value)
 	n := "nil"
 	if t != nil {
 		n = t.String()
 	}
 
-	if mapper, ok := toMonetMappers[n]; ok {
+	mappersMu.RLock()
+	mapper, ok := toMonetMappers[n]
+	mappersMu.RUnlock()
+	return mapper, ok
+}
+
+func convertToMonet(value driver.Value) (string, error) {
+	if mapper, ok := toMonetMapper(value); ok {
 		return mapper(value)
 	}
-	return "", fmt.Errorf("Type not supported: %v", t)
+
+	// a pointer is converted as the value it points to, and a nil
+	// pointer, slice or map as NULL
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return toNull(nil)
+		}
+		return convertToMonet(v.Elem().Interface())
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return toNull(nil)
+		}
+	}
+	return "", fmt.Errorf("Type not supported: %v", reflect.TypeOf(value))
 }
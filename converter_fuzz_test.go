@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzUnquote checks that unquote does not panic, returns nothing with an
+// error, and reads back the strings toQuotedString writes. The seed corpus
+// is in testdata/fuzz/FuzzUnquote.
+func FuzzUnquote(f *testing.F) {
+	for _, s := range []string{"", "plain", "it\\'s", "\\\"", "\\\\", "a\\tb\\n", "\\u00e9", "\\x41", "\\377", "\\"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		u, err := unquote(s)
+		if err != nil && u != "" {
+			t.Errorf("unquote(%q) returned %q with error %v", s, u, err)
+		}
+		if !strings.Contains(s, "\\") && (err != nil || u != s) {
+			t.Errorf("unquote(%q) = %q, %v, expected it unchanged", s, u, err)
+		}
+
+		// strip is given any cell the server sends
+		strip(s)
+
+		q, _ := toQuotedString(s)
+		v, err := strip(q)
+		if err != nil || v != s {
+			t.Errorf("strip(%q) = %q, %v, expected: %q", q, v, err, s)
+		}
+	})
+}
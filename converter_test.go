@@ -7,6 +7,14 @@ package monetdb
 import (
 	"bytes"
 	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,13 +40,68 @@ func TestConvertToMonet(t *testing.T) {
 		tc{true, "true"},
 		tc{false, "false"},
 		tc{nil, "NULL"},
-		tc{[]byte{1, 2, 3}, "'" + string([]byte{1, 2, 3}) + "'"},
+		tc{[]byte{1, 2, 3}, "blob '010203'"},
+		tc{[]byte{0xde, 0xad, 0xbe, 0xef}, "blob 'DEADBEEF'"},
+		tc{[]byte{}, "blob ''"},
+		tc{[]byte(nil), "NULL"},
+		tc{json.RawMessage(`{"a": "it's"}`), `json '{"a": "it\'s"}'`},
+		tc{json.RawMessage("[1, 2]"), "json '[1, 2]'"},
+		tc{json.RawMessage(nil), "NULL"},
 		tc{Time{10, 20, 30}, "'10:20:30'"},
 		tc{Date{2001, time.January, 2}, "'2001-01-02'"},
+		tc{TimeTZ{Time{12, 34, 56}, 7200}, "'12:34:56+02:00'"},
+		tc{TimeTZ{Time{1, 2, 3}, -19800}, "'01:02:03-05:30'"},
 		tc{time.Date(2001, time.January, 2, 10, 20, 30, 0, time.FixedZone("CET", 3600)),
-			"'2001-01-02 10:20:30 +0100 CET'"},
+			"'2001-01-02 10:20:30'"},
+		tc{TimestampTZ{time.Date(2001, time.January, 2, 10, 20, 30, 0, time.FixedZone("CET", 3600))},
+			"'2001-01-02 10:20:30+01:00'"},
+		tc{Timestamp{time.Date(2001, time.January, 2, 10, 20, 30, 123456789, time.UTC), 0},
+			"'2001-01-02 10:20:30'"},
+		tc{Timestamp{time.Date(2001, time.January, 2, 10, 20, 30, 123456789, time.UTC), 3},
+			"'2001-01-02 10:20:30.123'"},
+		tc{Timestamp{time.Date(2001, time.January, 2, 10, 20, 30, 120000000, time.UTC), 6},
+			"'2001-01-02 10:20:30.120000'"},
 	}
 
+	yes := true
+	f, _, _ := big.ParseFloat("12345678901234567890.123456789", 10, 200, big.ToNearestEven)
+	tcs = append(tcs,
+		tc{json.Number("123456789012345678901234567890.0001"), "123456789012345678901234567890.0001"},
+		tc{json.Number("-1.5e-10"), "-1.5e-10"},
+		tc{Numeric("12.50"), "12.50"},
+		tc{BoolAsInt(true), "1"},
+		tc{BoolAsInt(false), "0"},
+		tc{Numeric("-.5"), "-.5"},
+		tc{f, "12345678901234567890.123456789"},
+		tc{(*big.Float)(nil), "NULL"},
+		tc{big.NewInt(-42), "-42"},
+		tc{new(big.Int).Lsh(big.NewInt(1), 70), "1180591620717411303424"},
+		tc{(*big.Int)(nil), "NULL"},
+		tc{(*bool)(nil), "NULL"},
+		tc{&yes, "true"},
+		tc{OID(42), "42"},
+		tc{90500 * time.Millisecond, "INTERVAL '90.5' SECOND"},
+		tc{time.Hour, "INTERVAL '3600' SECOND"},
+		tc{-90 * time.Minute, "INTERVAL '-5400' SECOND"},
+		tc{-time.Millisecond, "INTERVAL '-0.001' SECOND"},
+		tc{Interval{Months: 18}, "INTERVAL '18' MONTH"},
+		tc{Interval{Months: -2}, "INTERVAL '-2' MONTH"},
+		tc{Interval{Duration: 90 * time.Second}, "INTERVAL '90' SECOND"},
+		tc{Interval{}, "INTERVAL '0' SECOND"},
+		tc{(*int)(nil), "NULL"},
+		tc{(*string)(nil), "NULL"},
+		tc{[]string(nil), "NULL"},
+	)
+
+	i := 42
+	pi := &i
+	s := "it's"
+	tcs = append(tcs,
+		tc{&i, "42"},
+		tc{&pi, "42"},
+		tc{&s, "'it\\'s'"},
+	)
+
 	for _, c := range tcs {
 		s, err := convertToMonet(c.v)
 		if err != nil {
@@ -62,6 +125,41 @@ func TestConvertToGo(t *testing.T) {
 		tc{"32", "int", int32(32)},
 		tc{"32", "mediumint", int32(32)},
 		tc{"64", "bigint", int64(64)},
+		tc{"9223372036854775807", "bigint", int64(math.MaxInt64)},
+		tc{"-9223372036854775808", "bigint", int64(math.MinInt64)},
+		tc{"+64", "bigint", int64(64)},
+		tc{" 64 ", "bigint", int64(64)},
+		tc{"32", "wrd", int64(32)},
+		tc{"42@0", "oid", OID(42)},
+		tc{"'text'", "character_varying", "text"},
+		tc{"'t'", "character", "t"},
+		tc{"'long text'", "character large object", "long text"},
+		tc{"6.4", "double_precision", float64(6.4)},
+		tc{"12.5", "numeric", float64(12.5)},
+		tc{"12.50", "decimal(10,2)", float64(12.5)},
+		tc{"'text'", "varchar(255)", "text"},
+		tc{"'y'", "char(1)", "y"},
+		tc{"'y'", "character varying (1)", "y"},
+		tc{"7", "oid", OID(7)},
+		tc{"14", "month_interval", Interval{Months: 14}},
+		tc{"-3", "month_interval", Interval{Months: -3}},
+		tc{"1-06", "month_interval", Interval{Months: 18}},
+		tc{"0-11", "month_interval", Interval{Months: 11}},
+		tc{"-2-03", "month_interval", Interval{Months: -27}},
+		tc{"3.000", "sec_interval", 3 * time.Second},
+		tc{"90.500", "sec_interval", 90500 * time.Millisecond},
+		tc{"-0.001", "sec_interval", -time.Millisecond},
+		tc{"90.5", "sec_interval", 90500 * time.Millisecond},
+		tc{"04:05:06", "sec_interval", 4*time.Hour + 5*time.Minute + 6*time.Second},
+		tc{"3 04:05:06", "sec_interval", 76*time.Hour + 5*time.Minute + 6*time.Second},
+		tc{"3 days, 04:05:06.25", "sec_interval", 76*time.Hour + 5*time.Minute + 6250*time.Millisecond},
+		tc{"1 day, 00:00:00", "sec_interval", 24 * time.Hour},
+		tc{"-00:00:01.5", "sec_interval", -1500 * time.Millisecond},
+		tc{"-300.000", "sec_interval", -5 * time.Minute},
+		tc{"-00:05:00", "sec_interval", -5 * time.Minute},
+		tc{"2", "day_interval", "2"},
+		tc{"7200.000", "hour_interval", "7200.000"},
+		tc{"4294967296", "wrd", int64(4294967296)},
 		tc{"64", "longint", int64(64)},
 		tc{"64", "hugeint", int64(64)},
 		tc{"64", "serial", int64(64)},
@@ -71,15 +169,56 @@ func TestConvertToGo(t *testing.T) {
 		tc{"6.4", "decimal", float64(6.4)},
 		tc{"true", "boolean", true},
 		tc{"false", "boolean", false},
+		tc{"t", "boolean", true},
+		tc{"f", "boolean", false},
+		tc{"True", "boolean", true},
+		tc{"FALSE", "boolean", false},
+		tc{"NULL", "boolean", nil},
+		tc{"1", "boolean", true},
+		tc{"0", "boolean", false},
+		tc{"T", "boolean", true},
+		tc{"F", "boolean", false},
 		tc{"10:20:30", "time", Time{10, 20, 30}},
+		tc{"12:34:56+02:00", "timetz", TimeTZ{Time{12, 34, 56}, 7200}},
+		tc{"12:34:56.123456-05:30", "timetz", TimeTZ{Time{12, 34, 56}, -19800}},
+		tc{"00:00:01Z", "timetz", TimeTZ{Time{0, 0, 1}, 0}},
 		tc{"2001-01-02", "date", Date{2001, time.January, 2}},
+		tc{"0087-03-02", "date", Date{87, time.March, 2}},
+		tc{"87-03-02", "date", Date{87, time.March, 2}},
+		tc{"0001-01-01", "date", Date{1, time.January, 1}},
+		tc{"1-01-01", "date", Date{1, time.January, 1}},
+		tc{"-87-03-02", "date", Date{-87, time.March, 2}},
+		tc{"87-03-02 10:20:30", "timestamp", time.Date(87, time.March, 2, 10, 20, 30, 0, time.UTC)},
+		tc{"2023-01-02T15:04:05", "timestamp", time.Date(2023, time.January, 2, 15, 4, 5, 0, time.UTC)},
+		tc{"2023-01-02T15:04:05.5", "timestamp", time.Date(2023, time.January, 2, 15, 4, 5, 5e8, time.UTC)},
 		tc{"'string'", "char", "string"},
 		tc{"'string'", "varchar", "string"},
+		tc{"'NULL'", "varchar", "NULL"},
+		tc{"\"NULL\"", "clob", "NULL"},
+		tc{"NULL", "varchar", nil},
 		tc{"'quoted \"string\"'", "char", "quoted \"string\""},
 		tc{"'quoted \\'string\\''", "char", "quoted 'string'"},
 		tc{"'quoted \\\\\\'string\\\\\\''", "char", "quoted \\'string\\'"},
 		tc{"'back\\\\slashed'", "char", "back\\slashed"},
 		tc{"'ABC'", "blob", []uint8{0x41, 0x42, 0x43}},
+		tc{"'\xde\xad\xbe\xef'", "blob", []uint8{0xde, 0xad, 0xbe, 0xef}},
+		tc{"0xDEADBEEF", "blob", []uint8{0xde, 0xad, 0xbe, 0xef}},
+		tc{"DEADBEEF", "blob", []uint8{0xde, 0xad, 0xbe, 0xef}},
+		tc{"4:DEADBEEF", "blob", []uint8{0xde, 0xad, 0xbe, 0xef}},
+		tc{"0:", "blob", []uint8{}},
+		tc{"'[1, 2, 3]'", "json", "[1, 2, 3]"},
+		tc{"'{\"a\": [1, {\"b\": null}]}'", "json", "{\"a\": [1, {\"b\": null}]}"},
+		tc{"\"{\\\"a\\\": 1}\"", "json", "{\"a\": 1}"},
+		tc{"\"\\\"text\\\"\"", "json", "\"text\""},
+		tc{"42", "json", "42"},
+		tc{"-1.5", "json", "-1.5"},
+		tc{"true", "json", "true"},
+		tc{"{\"a\": 1}", "varchar(json)", "{\"a\": 1}"},
+		tc{"{\"a\": 1}", "varchar:json", "{\"a\": 1}"},
+		tc{"[1]", "json:array", "[1]"},
+		tc{"'[1]'", "json(object)", "[1]"},
+		tc{"42", "JSON", "42"},
+		tc{"\"it's \\\"quoted\\\"\"", "varchar", "it's \"quoted\""},
 	}
 
 	for _, c := range tcs {
@@ -101,6 +240,139 @@ func TestConvertToGo(t *testing.T) {
 	}
 }
 
+func TestTimestampRoundTrip(t *testing.T) {
+	for _, ns := range []int{123456000, 123456789, 1000, 120000000, 999999000, 0} {
+		ts := time.Date(2015, time.March, 4, 22, 10, 5, ns, time.UTC)
+		e := ts.Truncate(time.Microsecond)
+		for _, v := range []interface{}{ts, Timestamp{ts, 6}} {
+			s, err := convertToMonet(v)
+			if err != nil {
+				t.Fatalf("Error converting value: %v", err)
+			}
+			r, err := convertToGo(s[1:len(s)-1], "timestamp")
+			if err != nil {
+				t.Fatalf("Error converting value: %s -> %v", s, err)
+			}
+			if r := r.(time.Time); !r.Equal(e) {
+				t.Errorf("Invalid value for %s: %v, expected: %v", s, r, e)
+			}
+		}
+	}
+}
+
+func TestNegativeDurationRoundTrip(t *testing.T) {
+	for _, d := range []time.Duration{-5 * time.Minute, -1500 * time.Millisecond, -26 * time.Hour} {
+		s, err := convertToMonet(d)
+		if err != nil {
+			t.Fatalf("Error converting value: %v", err)
+		}
+		lit := strings.TrimSuffix(strings.TrimPrefix(s, "INTERVAL '"), "' SECOND")
+		v, err := convertToGo(lit, "sec_interval")
+		if err != nil {
+			t.Fatalf("Error converting value: %s -> %v", s, err)
+		}
+		if v != d {
+			t.Errorf("Invalid value for %s: %v, expected: %v", s, v, d)
+		}
+	}
+}
+
+func TestTimestampTZRoundTrip(t *testing.T) {
+	loc := time.FixedZone("", -(5*3600 + 30*60))
+	e := time.Date(2015, time.March, 4, 22, 10, 5, 123456000, loc)
+
+	s, err := convertToMonet(TimestampTZ{e})
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	v, err := convertToGo(s[1:len(s)-1], "timestamptz")
+	if err != nil {
+		t.Fatalf("Error converting value: %s -> %v", s, err)
+	}
+	if r := v.(time.Time); !r.Equal(e) {
+		t.Errorf("Invalid value: %v, expected: %v", r, e)
+	}
+}
+
+func TestConvertToGoInvalidEscape(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	_, err = convertToGo("'bad \\q escape'", "varchar")
+	os.Stdout = stdout
+	w.Close()
+
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Invalid error: %v, expected: %v", err, strconv.ErrSyntax)
+	}
+	if out, _ := ioutil.ReadAll(r); len(out) > 0 {
+		t.Errorf("Unexpected output: %s", out)
+	}
+}
+
+func TestConvertToMonetInvalidNumber(t *testing.T) {
+	if _, err := convertToMonet(json.Number("1; DROP TABLE t")); err == nil {
+		t.Errorf("Expected error converting invalid json.Number")
+	}
+	for _, n := range []Numeric{"12,50", "", "1e", "'1'", "0x10"} {
+		if _, err := convertToMonet(n); err == nil {
+			t.Errorf("Expected error converting invalid Numeric %q", n)
+		}
+	}
+}
+
+func TestNegativeZeroDecimal(t *testing.T) {
+	v, err := convertToGo("-0.00", "decimal")
+	if f, ok := v.(float64); err != nil || !ok || f != 0 || math.Signbit(f) {
+		t.Errorf("Invalid decimal: %v (%v), expected: 0", v, err)
+	}
+	v, err = toExactDecimal("-0.00")
+	if err != nil || v != "0.00" {
+		t.Errorf("Invalid exact decimal: %v (%v), expected: 0.00", v, err)
+	}
+	v, err = toExactDecimal("-0.01")
+	if err != nil || v != "-0.01" {
+		t.Errorf("Invalid exact decimal: %v (%v), expected: -0.01", v, err)
+	}
+}
+
+func TestConvertToMonetMixedInterval(t *testing.T) {
+	if _, err := convertToMonet(Interval{Months: 1, Duration: time.Hour}); err == nil {
+		t.Errorf("Expected error converting interval with months and a duration")
+	}
+}
+
+func TestConvertToGoInvalidBlob(t *testing.T) {
+	if _, err := convertToGo("3:DEADBEEF", "blob"); err == nil {
+		t.Errorf("Expected error converting blob with wrong length prefix")
+	}
+	if _, err := convertToGo("0xDEADBEE", "blob"); err == nil {
+		t.Errorf("Expected error converting blob with odd number of digits")
+	}
+}
+
+func TestConvertToGoInvalidBool(t *testing.T) {
+	if _, err := convertToGo("yes", "boolean"); err == nil {
+		t.Errorf("Expected error converting invalid boolean")
+	}
+}
+
+func TestConvertToGoInvalidInterval(t *testing.T) {
+	for _, v := range []string{"04:05", "3 weeks, 04:05:06", "00:00:-1", "x"} {
+		if _, err := convertToGo(v, "sec_interval"); err == nil {
+			t.Errorf("Expected error converting interval %q", v)
+		}
+	}
+	for _, v := range []string{"1-12", "1--1", "--1", "1-x", ""} {
+		if _, err := convertToGo(v, "month_interval"); err == nil {
+			t.Errorf("Expected error converting month interval %q", v)
+		}
+	}
+}
+
 func compareByteArray(t *testing.T, val []byte, e driver.Value) bool {
 	switch exp := e.(type) {
 	case []byte:
@@ -109,3 +381,303 @@ func compareByteArray(t *testing.T, val []byte, e driver.Value) bool {
 		return false
 	}
 }
+
+type celsius float64
+
+func TestCheckNamedValue(t *testing.T) {
+	s := newStmt(nil, "")
+
+	for _, v := range []driver.Value{1, "string", nil, Date{2001, time.January, 2}} {
+		if err := s.CheckNamedValue(&driver.NamedValue{Value: v}); err != nil {
+			t.Errorf("Unexpected error checking value: %v -> %v", v, err)
+		}
+	}
+
+	v := celsius(21.5)
+	if err := s.CheckNamedValue(&driver.NamedValue{Value: v}); err != driver.ErrSkip {
+		t.Fatalf("Invalid error checking value: %v, expected: %v", err, driver.ErrSkip)
+	}
+	dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	if s, err := convertToMonet(dv); err != nil || s != "21.5" {
+		t.Errorf("Invalid value: %s (%v), expected: 21.5", s, err)
+	}
+}
+
+func TestConvertToGoInvalidInt(t *testing.T) {
+	v, err := convertToGo("42.0", "int")
+	if err != nil || v != int32(42) {
+		t.Errorf("Invalid value: %v (%v), expected: %d", v, err, 42)
+	}
+
+	type tc struct {
+		v        string
+		dataType string
+		err      string
+	}
+	var tcs = []tc{
+		tc{"abc", "int", "Invalid value for int32: abc: invalid syntax"},
+		tc{"42.5", "bigint", "Invalid value for int64: 42.5: invalid syntax"},
+		tc{"300", "tinyint", "Invalid value for int8: 300: value out of range"},
+		tc{"9223372036854775808", "bigint", "Invalid value for int64: 9223372036854775808: value out of range"},
+		tc{"-9223372036854775809", "bigint", "Invalid value for int64: -9223372036854775809: value out of range"},
+	}
+	for _, c := range tcs {
+		_, err := convertToGo(c.v, c.dataType)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Invalid error: %v, expected: %s", err, c.err)
+		}
+	}
+}
+
+func TestConvertToGoReal(t *testing.T) {
+	v, err := convertToGo("1.5e-10", "real")
+	if err != nil || v != float32(1.5e-10) {
+		t.Errorf("Invalid value: %v (%v), expected: %v", v, err, float32(1.5e-10))
+	}
+
+	v, err = convertToGo("NULL", "real")
+	if err != nil || v != nil {
+		t.Errorf("Invalid value: %v (%v), expected: nil", v, err)
+	}
+
+	v, err = convertToGo("1e39", "real")
+	if err == nil {
+		t.Errorf("Expected overflow error, got: %v", v)
+	}
+}
+
+func TestConvertToGoHugeIntOverflow(t *testing.T) {
+	v, err := convertToGo("123456789012345678901234567890", "hugeint")
+	if err == nil {
+		t.Errorf("Expected overflow error, got: %v", v)
+	} else if !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	v, err = convertToGo("-9223372036854775808", "hugeint")
+	if err != nil || v != int64(-9223372036854775808) {
+		t.Errorf("Invalid value: %v (%v), expected: %d", v, err, int64(-9223372036854775808))
+	}
+}
+
+func TestConvertToGoRawStrings(t *testing.T) {
+	v := "'bad \\q escape'"
+	if _, err := convertToGo(v, "varchar"); err == nil {
+		t.Errorf("Expected error converting invalid escape")
+	}
+
+	mappers := connToGoMappers(Config{RawStrings: true})
+	r, err := convertToGoWith(mappers, v, "varchar")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	if !bytes.Equal(r.([]byte), []byte("bad \\q escape")) {
+		t.Errorf("Invalid value: %q, expected: %q", r, "bad \\q escape")
+	}
+}
+
+func TestConvertToGoPadded(t *testing.T) {
+	for _, dt := range []string{"char", "varchar", "clob"} {
+		v, err := convertToGo(" '  padded  '\t", dt)
+		if err != nil {
+			t.Fatalf("Error converting value: %v", err)
+		}
+		if v != "  padded  " {
+			t.Errorf("Invalid value: %q (%s), expected: %q", v, dt, "  padded  ")
+		}
+	}
+}
+
+func TestConvertToGoTrimChar(t *testing.T) {
+	mappers := connToGoMappers(Config{TrimChar: true})
+
+	v, err := convertToGoWith(mappers, "'  char    '", "char")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	if v != "  char" {
+		t.Errorf("Invalid value: %q, expected: %q", v, "  char")
+	}
+
+	for _, dt := range []string{"varchar", "clob"} {
+		e, _ := convertToGo("'text    '", dt)
+		if v, _ := convertToGoWith(mappers, "'text    '", dt); v != e {
+			t.Errorf("Invalid value: %q (%s), expected: %q", v, dt, e)
+		}
+	}
+}
+
+func TestQuotedStringRoundTrip(t *testing.T) {
+	for _, s := range []string{"line\nbreak", "tab\tstop", "carriage\r\nreturn", "it's a \\ mix\n"} {
+		q, err := convertToMonet(s)
+		if err != nil {
+			t.Fatalf("Error converting value: %v", err)
+		}
+		if strings.ContainsAny(q, "\n\r\t") {
+			t.Errorf("Control character not escaped: %q", q)
+		}
+		v, err := convertToGo(q, "varchar")
+		if err != nil {
+			t.Fatalf("Error converting value: %v", err)
+		}
+		if v != s {
+			t.Errorf("Invalid value: %q, expected: %q", v, s)
+		}
+	}
+}
+
+func TestConvertToGoMixedEscapes(t *testing.T) {
+	v, err := convertToGo("'naïve \\'café\\' \\\\ 東京\\t€'", "clob")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	e := "naïve 'café' \\ 東京\t€"
+	if v != e {
+		t.Errorf("Invalid value: %q, expected: %q", v, e)
+	}
+}
+
+func BenchmarkConvertToGoLargeClob(b *testing.B) {
+	// 5MB of text with a quote escaped every kilobyte
+	chunk := strings.Repeat("x", 1022) + "\\'"
+	v := "'" + strings.Repeat(chunk, 5*1024) + "'"
+
+	b.SetBytes(int64(len(v)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := convertToGo(v, "clob"); err != nil {
+			b.Fatalf("Error converting value: %v", err)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tcs := [][]string{
+		[]string{"orders", `"orders"`},
+		[]string{`my "table"`, `"my ""table"""`},
+		[]string{"select", `"select"`},
+	}
+	for _, c := range tcs {
+		if v, err := QuoteIdentifier(c[0]); err != nil || v != c[1] {
+			t.Errorf("Invalid identifier: %s (%v), expected: %s", v, err, c[1])
+		}
+	}
+
+	if _, err := QuoteIdentifier("bad\x00name"); err == nil {
+		t.Errorf("Expected error quoting identifier with NUL byte")
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	type tc struct {
+		v driver.Value
+		e string
+	}
+	tcs := []tc{
+		tc{"it's", `'it\'s'`},
+		tc{int64(42), "42"},
+		tc{time.Date(2015, time.March, 4, 22, 10, 5, 0, time.UTC), "'2015-03-04 22:10:05'"},
+		tc{nil, "NULL"},
+		tc{[]byte{0xca, 0xfe}, "blob 'CAFE'"},
+	}
+	for _, c := range tcs {
+		if v, err := Literal(c.v); err != nil || v != c.e {
+			t.Errorf("Invalid literal: %s (%v), expected: %s", v, err, c.e)
+		}
+	}
+
+	if _, err := Literal(struct{}{}); err == nil {
+		t.Errorf("Expected error for a literal of an unsupported type")
+	}
+}
+
+func TestConvertToGoLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("Time zone database not available: %v", err)
+	}
+	mappers := connToGoMappers(Config{Location: loc})
+
+	v, err := convertToGoWith(mappers, "2020-07-01 12:30:00.000000", "timestamp")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	e := time.Date(2020, 7, 1, 12, 30, 0, 0, loc)
+	if ts := v.(time.Time); !ts.Equal(e) || ts.Location() != loc {
+		t.Errorf("Invalid timestamp: %v, expected: %v", ts, e)
+	}
+
+	v, err = convertToGoWith(mappers, "2020-07-01 12:30:00.000000+00:00", "timestamptz")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	e = time.Date(2020, 7, 1, 12, 30, 0, 0, time.UTC)
+	if ts := v.(time.Time); !ts.Equal(e) {
+		t.Errorf("Invalid timestamp: %v, expected: %v", ts, e)
+	}
+}
+
+func TestConvertToGoForceUTC(t *testing.T) {
+	mappers := connToGoMappers(Config{ForceUTC: true, TimeZone: time.FixedZone("", 5*3600)})
+
+	v, err := convertToGoWith(mappers, "2020-07-01 12:30:00.000000+05:00", "timestamptz")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	e := time.Date(2020, 7, 1, 7, 30, 0, 0, time.UTC)
+	if ts := v.(time.Time); !ts.Equal(e) || ts.Location() != time.UTC {
+		t.Errorf("Invalid timestamp: %v, expected: %v", ts, e)
+	}
+
+	v, err = convertToGoWith(mappers, "2020-07-01 12:30:00.000000", "timestamp")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	e = time.Date(2020, 7, 1, 12, 30, 0, 0, time.UTC)
+	if ts := v.(time.Time); !ts.Equal(e) || ts.Location() != time.UTC {
+		t.Errorf("Invalid timestamp: %v, expected: %v", ts, e)
+	}
+}
+
+func TestConvertToGoEmptyNumeric(t *testing.T) {
+	for _, conv := range []toGoConverter{toInt8, toInt16, toInt32, toInt64, toHugeInt, toFloat, toDouble} {
+		if _, err := conv(""); !errors.Is(err, ErrEmptyNumeric) {
+			t.Errorf("Invalid error: %v, expected: %v", err, ErrEmptyNumeric)
+		}
+	}
+	if _, err := convertToGo("  ", "int"); !errors.Is(err, ErrEmptyNumeric) {
+		t.Errorf("Invalid error for blank int: %v", err)
+	}
+}
+
+func TestConvertToGoISOTimestamp(t *testing.T) {
+	v, err := convertToGo("2023-01-02T15:04:05.123456+02:00", "timestamptz")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	e := time.Date(2023, time.January, 2, 13, 4, 5, 123456000, time.UTC)
+	ts := v.(time.Time)
+	if !ts.Equal(e) {
+		t.Errorf("Invalid timestamp: %v, expected: %v", ts, e)
+	}
+	if _, offset := ts.Zone(); offset != 2*3600 {
+		t.Errorf("Invalid offset: %d, expected: %d", offset, 2*3600)
+	}
+}
+
+func TestTimeTZRoundTrip(t *testing.T) {
+	v, err := convertToGo("12:34:56+02:00", "timetz")
+	if err != nil {
+		t.Fatalf("Error converting value: %v", err)
+	}
+	s, err := convertToMonet(v)
+	if err != nil {
+		t.Fatalf("Error converting %v back: %v", v, err)
+	}
+	if s != "'12:34:56+02:00'" {
+		t.Errorf("Invalid literal: %s, expected: %s", s, "'12:34:56+02:00'")
+	}
+}
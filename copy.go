@@ -0,0 +1,332 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const copyChunkSize = 64 * 1024
+
+// CopyOptions describes the CSV format used by CopyFromReader and
+// CopyToWriter.
+type CopyOptions struct {
+	// Delimiter separates the fields of a record. Defaults to ",".
+	Delimiter string
+
+	// Quote is the character fields can be quoted with.
+	// Defaults to "\"".
+	Quote string
+
+	// Null is the text of a NULL field, of any type. Defaults to the
+	// empty string, which cannot tell NULL apart from an empty string
+	// or blob, so data with those should use another one, such as
+	// "NULL", to be loaded back as it was exported.
+	Null string
+
+	// SkipHeader skips the first line of the input. It is ignored by
+	// CopyToWriter.
+	SkipHeader bool
+}
+
+func (o CopyOptions) delimiter() string {
+	if o.Delimiter == "" {
+		return ","
+	}
+	return o.Delimiter
+}
+
+func (o CopyOptions) quote() string {
+	if o.Quote == "" {
+		return "\""
+	}
+	return o.Quote
+}
+
+// copyInto returns the COPY INTO statement loading CSV data into the
+// given table.
+func (o CopyOptions) copyInto(table string) string {
+	offset := ""
+	if o.SkipHeader {
+		offset = "OFFSET 2 "
+	}
+
+	d, _ := toQuotedString(o.delimiter())
+	q, _ := toQuotedString(o.quote())
+	n, _ := toQuotedString(o.Null)
+	return fmt.Sprintf("COPY %sINTO %s FROM STDIN USING DELIMITERS %s, '\\n', %s NULL AS %s",
+		offset, table, d, q, n)
+}
+
+// copyOut returns the COPY INTO statement exporting the result of a query
+// as CSV data.
+func (o CopyOptions) copyOut(query string) string {
+	d, _ := toQuotedString(o.delimiter())
+	q, _ := toQuotedString(o.quote())
+	n, _ := toQuotedString(o.Null)
+	return fmt.Sprintf("COPY %s INTO STDOUT USING DELIMITERS %s, '\\n', %s NULL AS %s",
+		query, d, q, n)
+}
+
+// CopyFromReader loads the CSV data read from r into a table using
+// COPY INTO ... FROM STDIN, without converting it to Go values first.
+// The table name is used as is. It returns the number of rows loaded.
+// It is reached through sql.Conn.Raw.
+//
+// If ctx is done before all data is sent, the connection is closed,
+// which makes the server roll back the rows loaded so far, and ctx.Err()
+// is returned. database/sql then discards the connection.
+func (c *Conn) CopyFromReader(ctx context.Context, table string, r io.Reader, opts CopyOptions) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.mapi == nil {
+		return 0, driver.ErrBadConn
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	defer stop()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "s%s;\n", opts.copyInto(table))
+
+	buf := make([]byte, copyChunkSize)
+	eof := false
+	for {
+		if !eof {
+			n, err := io.ReadFull(r, buf)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				// Dropping the connection aborts the load,
+				// ending the input would commit part of it.
+				c.mapi.Disconnect()
+				return 0, err
+			}
+			msg.Write(buf[:n])
+		}
+		if err := ctx.Err(); err != nil {
+			// there is no way to abort the load but to hang up
+			c.mapi.Disconnect()
+			return 0, err
+		}
+
+		// an empty message ends the input
+		sent := msg.Len()
+		resp, err := c.copyCmd(msg.Bytes())
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, err
+		}
+		msg.Reset()
+
+		if resp != mapi_MSG_MORE {
+			return c.copyResult(resp)
+		}
+		if eof && sent == 0 {
+			return 0, fmt.Errorf("Unexpected prompt after end of COPY data")
+		}
+	}
+}
+
+// copyCmd sends a message of COPY data and returns the server's response.
+func (c *Conn) copyCmd(data []byte) (string, error) {
+	if err := c.mapi.putBlock(data); err != nil {
+		c.mapi.Disconnect()
+		return "", fmt.Errorf("Connection lost: %w", err)
+	}
+	r, err := c.mapi.getBlock()
+	if err != nil {
+		c.mapi.Disconnect()
+		return "", fmt.Errorf("Connection lost: %w", err)
+	}
+	return string(r), nil
+}
+
+// copyResult returns the number of rows loaded by a COPY INTO, or the
+// error the server reported.
+func (c *Conn) copyResult(resp string) (int64, error) {
+	if len(resp) > 0 && resp[:1] == mapi_MSG_ERROR {
+		return 0, fmt.Errorf("Database error: %s", strings.TrimSpace(resp[1:]))
+	}
+
+	s := newStmt(c, "")
+	if err := s.storeResult(resp); err != nil {
+		return 0, err
+	}
+	return int64(s.rowCount), nil
+}
+
+// CopyToWriter writes the result of a query to w as CSV data, using
+// COPY ... INTO STDOUT, without converting it to Go values first. The
+// data is written as it arrives. It returns the number of rows the server
+// reports it exported, which is 0 if it doesn't get that far. It is
+// reached through sql.Conn.Raw.
+//
+// If ctx is done or writing to w fails before all data is read, the
+// connection is closed, as the rest of the data cannot be skipped, and
+// database/sql discards it.
+func (c *Conn) CopyToWriter(ctx context.Context, query string, w io.Writer, opts CopyOptions) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.mapi == nil {
+		return 0, driver.ErrBadConn
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	defer stop()
+
+	if err := c.mapi.putBlock([]byte(fmt.Sprintf("s%s;", opts.copyOut(query)))); err != nil {
+		c.mapi.Disconnect()
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, driver.ErrBadConn
+	}
+
+	e := &copyExport{w: w}
+	err := c.mapi.readMessage(e.write)
+	if err == nil {
+		err = e.flush()
+	}
+	if err != nil {
+		c.mapi.Disconnect()
+		if ctx.Err() != nil {
+			return e.rows, ctx.Err()
+		}
+		return e.rows, err
+	}
+	if e.err != nil {
+		return e.rows, e.err
+	}
+	return e.rows, nil
+}
+
+// copyUpdate matches the line reporting the number of rows a COPY INTO
+// STDOUT exported.
+var copyUpdate = regexp.MustCompile(`^&2 (\d+) -?\d+\n?$`)
+
+// copyExport writes the CSV lines of a COPY INTO STDOUT response, which
+// may be split across blocks anywhere, to w. The response ends with the
+// update line, which may be followed by an error. A line starting with !
+// is only taken as an error before any data or after the update line, as
+// elsewhere it may be data; so is a line that looks like the update line
+// but is followed by more data.
+type copyExport struct {
+	w       io.Writer
+	partial []byte
+
+	// data is whether any data was written.
+	data bool
+	// update holds a line that looks like the update line until it
+	// turns out to be the last one.
+	update []byte
+	// updated is whether the update line was read; rows is the number
+	// of rows it reports.
+	updated bool
+	rows    int64
+
+	// err is the error the server reported.
+	err error
+}
+
+func (e *copyExport) write(p []byte) error {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			e.partial = append(e.partial, p...)
+			return nil
+		}
+		line := p[:i+1]
+		if len(e.partial) > 0 {
+			e.partial = append(e.partial, line...)
+			line = e.partial
+		}
+		if err := e.line(line); err != nil {
+			return err
+		}
+		e.partial = e.partial[:0]
+		p = p[i+1:]
+	}
+	return nil
+}
+
+// flush handles a last line without a newline, and the update line held
+// back until the end of the response.
+func (e *copyExport) flush() error {
+	if len(e.partial) > 0 {
+		err := e.line(e.partial)
+		e.partial = nil
+		if err != nil {
+			return err
+		}
+	}
+	if e.update != nil {
+		e.setUpdated()
+	}
+	return nil
+}
+
+func (e *copyExport) line(l []byte) error {
+	isError := len(l) > 0 && l[0] == mapi_MSG_ERROR[0]
+	if e.updated {
+		if isError {
+			e.serverError(l)
+		}
+		return nil
+	}
+	if e.update != nil {
+		if isError {
+			e.setUpdated()
+			e.serverError(l)
+			return nil
+		}
+		// it was data after all
+		if err := e.writeData(e.update); err != nil {
+			return err
+		}
+		e.update = nil
+	}
+
+	switch {
+	case copyUpdate.Match(l):
+		e.update = append([]byte(nil), l...)
+		return nil
+	case isError && !e.data:
+		e.serverError(l)
+		return nil
+	}
+	return e.writeData(l)
+}
+
+func (e *copyExport) writeData(l []byte) error {
+	e.data = true
+	_, err := e.w.Write(l)
+	return err
+}
+
+// setUpdated takes the line held in update as the update line.
+func (e *copyExport) setUpdated() {
+	m := copyUpdate.FindSubmatch(e.update)
+	e.rows, _ = strconv.ParseInt(string(m[1]), 10, 64)
+	e.update = nil
+	e.updated = true
+}
+
+func (e *copyExport) serverError(l []byte) {
+	if e.err == nil {
+		e.err = fmt.Errorf("Database error: %s", strings.TrimSpace(string(l[1:])))
+	}
+}
@@ -0,0 +1,403 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// copyServer returns a handler that answers a COPY INTO ... FROM STDIN,
+// asking for more data until the client ends the input. The statement
+// and data it receives are sent to received.
+func copyServer(received chan<- string, response string) func(*MapiConn) {
+	return func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		var b bytes.Buffer
+		for {
+			msg, err := m.getBlock()
+			if err != nil {
+				return
+			}
+			if len(msg) == 0 {
+				break
+			}
+			b.Write(msg)
+			m.putBlock([]byte(mapi_MSG_MORE))
+		}
+		received <- b.String()
+		m.putBlock([]byte(response))
+	}
+}
+
+func TestCopyFromReader(t *testing.T) {
+	received := make(chan string, 1)
+	srv := newFakeServer(t, copyServer(received, "&2 3 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	csv := "id;name\n1;\"one\"\n2;\"two; three\"\n3;NULL\n"
+	opts := CopyOptions{Delimiter: ";", Null: "NULL", SkipHeader: true}
+	var n int64
+	err = conn.Raw(func(dc interface{}) (err error) {
+		n, err = dc.(*Conn).CopyFromReader(context.Background(), "t", strings.NewReader(csv), opts)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error copying: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Invalid row count: %d, expected: %d", n, 3)
+	}
+
+	e := "sCOPY OFFSET 2 INTO t FROM STDIN USING DELIMITERS ';', '\\n', '\"' NULL AS 'NULL';\n" + csv
+	if r := <-received; r != e {
+		t.Errorf("Invalid data: %q, expected: %q", r, e)
+	}
+}
+
+func TestCopyFromReaderError(t *testing.T) {
+	received := make(chan string, 1)
+	srv := newFakeServer(t, copyServer(received, "!Failed to import table 't', line 2: column 1: Leftover data 'x'\n"))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.(*Conn).CopyFromReader(context.Background(), "t", strings.NewReader("1\n2,x\n"), CopyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "Leftover data") {
+		t.Errorf("Invalid error: %v, expected: Leftover data", err)
+	}
+}
+
+// cancelReader returns CSV rows, and calls cancel after the given number
+// of rows.
+type cancelReader struct {
+	rows   int
+	after  int
+	cancel func()
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	r.rows++
+	if r.rows == r.after {
+		r.cancel()
+	}
+	return copy(p, fmt.Sprintf("%d,%s\n", r.rows, strings.Repeat("x", 90))), nil
+}
+
+func TestCopyFromReaderCancel(t *testing.T) {
+	var mu sync.Mutex
+	loaded := 0
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		for {
+			msg, err := m.getBlock()
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(msg), "sCOPY") {
+				mu.Lock()
+				n := loaded
+				mu.Unlock()
+				m.putBlock([]byte("&1 0 1 1 1\n% .t # table_name\n" +
+					"% n # name\n% bigint # type\n% 1 # length\n" +
+					fmt.Sprintf("[ %d\t]\n", n)))
+				continue
+			}
+
+			// the rows are only loaded once the input ends
+			rows := strings.Count(string(msg), "\n") - 1
+			for len(msg) > 0 {
+				m.putBlock([]byte(mapi_MSG_MORE))
+				if msg, err = m.getBlock(); err != nil {
+					return
+				}
+				rows += strings.Count(string(msg), "\n")
+			}
+			mu.Lock()
+			loaded += rows
+			mu.Unlock()
+			m.putBlock([]byte(fmt.Sprintf("&2 %d -1\n", rows)))
+		}
+	})
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelReader{after: 1000, cancel: cancel}
+	err = conn.Raw(func(dc interface{}) error {
+		_, err := dc.(*Conn).CopyFromReader(ctx, "t", r, CopyOptions{})
+		return err
+	})
+	if err != context.Canceled {
+		t.Errorf("Invalid error: %v, expected: %v", err, context.Canceled)
+	}
+	conn.Close()
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Fatalf("Error querying after cancelled copy: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Invalid number of rows loaded: %d, expected: 0", n)
+	}
+}
+
+func TestCopyToWriter(t *testing.T) {
+	received := make(chan string, 1)
+	csv := "1;\"one\"\n2;\"two; three\"\n3;NULL\n"
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		cmd, err := m.getBlock()
+		if err != nil {
+			return
+		}
+		received <- string(cmd)
+		// split the lines across blocks
+		m.BlockSize = 7
+		m.putBlock([]byte(csv + "&2 3 -1\n"))
+		m.getBlock()
+	})
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	var b bytes.Buffer
+	opts := CopyOptions{Delimiter: ";", Null: "NULL"}
+	n, err := c.(*Conn).CopyToWriter(context.Background(), "SELECT * FROM t", &b, opts)
+	if err != nil {
+		t.Fatalf("Error copying: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Invalid row count: %d, expected: %d", n, 3)
+	}
+	if b.String() != csv {
+		t.Errorf("Invalid data: %q, expected: %q", b.String(), csv)
+	}
+
+	e := "sCOPY SELECT * FROM t INTO STDOUT USING DELIMITERS ';', '\\n', '\"' NULL AS 'NULL';"
+	if r := <-received; r != e {
+		t.Errorf("Invalid command: %q, expected: %q", r, e)
+	}
+}
+
+// tableServer returns a handler holding a table of an int and a blob
+// column, which it exports and loads with COPY INTO, writing NULL as the
+// null string of the statement.
+func tableServer(table [][]*string) func(*MapiConn) {
+	nullAs := regexp.MustCompile(`NULL AS '([^']*)';`)
+	return func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		for {
+			msg, err := m.getBlock()
+			if err != nil {
+				return
+			}
+			cmd := string(msg)
+			if i := strings.IndexByte(cmd, '\n'); i >= 0 {
+				cmd = cmd[:i]
+			}
+			null := nullAs.FindStringSubmatch(cmd)[1]
+
+			if strings.Contains(cmd, "INTO STDOUT") {
+				var b strings.Builder
+				for _, row := range table {
+					for i, v := range row {
+						if i > 0 {
+							b.WriteString(",")
+						}
+						if v == nil {
+							b.WriteString(null)
+						} else {
+							b.WriteString(*v)
+						}
+					}
+					b.WriteString("\n")
+				}
+				fmt.Fprintf(&b, "&2 %d -1\n", len(table))
+				m.putBlock([]byte(b.String()))
+				continue
+			}
+
+			data := string(msg[len(cmd)+1:])
+			for len(msg) > 0 {
+				m.putBlock([]byte(mapi_MSG_MORE))
+				if msg, err = m.getBlock(); err != nil {
+					return
+				}
+				data += string(msg)
+			}
+			table = table[:0]
+			for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+				var row []*string
+				for _, f := range strings.Split(line, ",") {
+					f := f
+					if f == null {
+						row = append(row, nil)
+					} else {
+						row = append(row, &f)
+					}
+				}
+				table = append(table, row)
+			}
+			m.putBlock([]byte(fmt.Sprintf("&2 %d -1\n", len(table))))
+		}
+	}
+}
+
+func TestCopyBlobRoundTrip(t *testing.T) {
+	str := func(s string) *string { return &s }
+	table := [][]*string{
+		{str("1"), str("CAFE")},
+		{str("2"), nil},
+		{str("3"), str("")},
+	}
+	srv := newFakeServer(t, tableServer(table))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	var b bytes.Buffer
+	opts := CopyOptions{Null: "NULL"}
+	if _, err := conn.CopyToWriter(context.Background(), "SELECT * FROM t", &b, opts); err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	if csv := "1,CAFE\n2,NULL\n3,\n"; b.String() != csv {
+		t.Errorf("Invalid data: %q, expected: %q", b.String(), csv)
+	}
+
+	n, err := conn.CopyFromReader(context.Background(), "t", &b, opts)
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Invalid row count: %d, expected: %d", n, 3)
+	}
+
+	b.Reset()
+	if _, err := conn.CopyToWriter(context.Background(), "SELECT * FROM t", &b, opts); err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	if csv := "1,CAFE\n2,NULL\n3,\n"; b.String() != csv {
+		t.Errorf("Invalid data after loading: %q, expected: %q", b.String(), csv)
+	}
+}
+
+func TestCopyToWriterError(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "!42S02!SELECT: no such table 't'\n"
+	}))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	var b bytes.Buffer
+	_, err = c.(*Conn).CopyToWriter(context.Background(), "SELECT * FROM t", &b, CopyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("Invalid error: %v, expected: no such table", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Invalid data written: %q", b.String())
+	}
+}
+
+func TestCopyToWriterResponses(t *testing.T) {
+	type tc struct {
+		response string
+		data     string
+		rows     int64
+		err      string
+	}
+	tcs := []tc{
+		// a value with a newline spans two lines, but is one row
+		tc{"1;\"a\nb\"\n&2 1 -1\n", "1;\"a\nb\"\n", 1, ""},
+		// lines like an error or the update line in the data
+		tc{"1;x\n!;y\n&2 2 -1\n", "1;x\n!;y\n", 2, ""},
+		tc{"&2 1 -1\n2;x\n&2 2 -1\n", "&2 1 -1\n2;x\n", 2, ""},
+		// an error after the data
+		tc{"1;x\n&2 1 -1\n!40000!COPY INTO: aborted\n", "1;x\n", 1, "aborted"},
+		// an error before any data
+		tc{"!42000!COPY INTO: invalid\n!more\n", "", 0, "invalid"},
+	}
+	for _, c := range tcs {
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			return c.response
+		}))
+
+		conn, err := (&Driver{}).Open(srv.dsn())
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+
+		var b bytes.Buffer
+		opts := CopyOptions{Delimiter: ";"}
+		n, err := conn.(*Conn).CopyToWriter(context.Background(), "SELECT * FROM t", &b, opts)
+		if c.err == "" && err != nil {
+			t.Errorf("Error copying %q: %v", c.response, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("Invalid error for %q: %v, expected: %s", c.response, err, c.err)
+		}
+		if n != c.rows {
+			t.Errorf("Invalid row count for %q: %d, expected: %d", c.response, n, c.rows)
+		}
+		if b.String() != c.data {
+			t.Errorf("Invalid data for %q: %q, expected: %q", c.response, b.String(), c.data)
+		}
+		conn.Close()
+		srv.Close()
+	}
+}
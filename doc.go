@@ -12,6 +12,8 @@ to the MonetDB server.
     [username[:password]@]hostname[:port]/database
 
 If the port is not specified, then the default port 50000 will be used.
+Characters such as '@', ':' and '/' in the username and password must be
+percent-encoded, e.g. "%40" for '@'.
 
 Please check the project's GitHub page for more complete documentation -
 https://github.com/fajran/go-monetdb
@@ -5,11 +5,17 @@
 package monetdb
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func init() {
@@ -19,12 +25,119 @@ func init() {
 type Driver struct {
 }
 
-type config struct {
+// Config holds the settings of connections, which are usually given as a
+// DSN. Use NewConnector to connect with it. Fields left zero have the
+// defaults of a DSN without options, so a Config with only a Database
+// connects to localhost on port 50000 in autocommit mode.
+type Config struct {
 	Username string
 	Password string
+	// Hostname defaults to "localhost" if empty, and Port to 50000 if
+	// zero.
 	Hostname string
 	Database string
 	Port     int
+
+	// Language is the language of the session, "sql" or "mal". MAL
+	// sessions get statements as they are, without the session setup
+	// of SQL sessions. It defaults to "sql" if empty.
+	Language string
+
+	// ApplicationName is reported to the server as the name of the
+	// client application. It defaults to the name of the executable.
+	ApplicationName string
+
+	// ClientRemark is reported to the server as a remark about the
+	// client, such as the deployment it belongs to.
+	ClientRemark string
+
+	// NoAutocommit turns autocommit off, so changes are only committed
+	// by an explicit COMMIT. Without it, every statement outside a
+	// transaction commits on its own.
+	NoAutocommit bool
+
+	// TrimChar removes the padding of CHAR(n) values.
+	TrimChar bool
+
+	// RawStrings returns string values as the bytes the server sent,
+	// without resolving their escape sequences.
+	RawStrings bool
+
+	// RawValues returns every value as the text the server sent, only
+	// removing the quotes around strings.
+	RawValues bool
+
+	// UnknownTypeRaw returns values of types the driver has no
+	// converter for as text, instead of failing the query.
+	UnknownTypeRaw bool
+
+	// ExactDecimals returns decimals as their text, such as "12.50",
+	// which can be scanned into a Decimal, instead of as a float64,
+	// which holds only 15 digits exactly.
+	ExactDecimals bool
+
+	// QualifiedNames prefixes column names with the name of their
+	// table, e.g. "a.id", to tell apart columns of the same name.
+	QualifiedNames bool
+
+	// StatementCacheSize is the number of prepared statements kept
+	// per connection for reuse. Zero disables the cache.
+	StatementCacheSize int
+
+	// TimeZone is set as the session time zone, and timestamps with
+	// time zone are returned in it. The server default is used if nil.
+	TimeZone *time.Location
+
+	// Location is the location timestamps without time zone are
+	// taken to be in. They are returned in UTC if nil.
+	Location *time.Location
+
+	// ForceUTC returns all timestamps, with and without time zone,
+	// in UTC. It cannot be combined with Location.
+	ForceUTC bool
+
+	// ReadOnly makes the server reject statements that write, by making
+	// every transaction of the session read-only.
+	ReadOnly bool
+
+	// QueryTimeout makes the server abort queries that run longer.
+	// It is rounded up to whole seconds. Zero means no timeout.
+	QueryTimeout time.Duration
+
+	// SlowQueryThreshold logs statements that take longer, see
+	// OnSlowQuery. Zero means no statements are logged.
+	SlowQueryThreshold time.Duration
+
+	// ConnectRetries is the number of times connecting is retried when
+	// the server cannot be reached. Failed logins are not retried.
+	ConnectRetries int
+
+	// ConnectRetryInterval is the time waited before the first retry.
+	// It doubles with every retry. Zero means one second.
+	ConnectRetryInterval time.Duration
+
+	// MaxRows is the number of rows a result set returns before
+	// reading it fails with ErrRowLimitExceeded. Zero means no limit.
+	MaxRows int
+
+	// BlockSize is the size of the MAPI blocks commands are sent in.
+	// Zero means the default of 8190 bytes.
+	BlockSize int
+
+	// ReadBuffer and WriteBuffer are the sizes in bytes of the receive
+	// and send buffers of the socket. Larger buffers help streaming large
+	// results and bulk loads over links with a high latency. Zero means
+	// the default of the system.
+	ReadBuffer  int
+	WriteBuffer int
+
+	// MaxStatementSize is the size in bytes of the largest statement
+	// sent to the server. Zero means no limit.
+	MaxStatementSize int
+
+	// Settings holds session variables that are set when connecting,
+	// given as set.<name>=<value> options.
+	Settings map[string]string
 }
 
 func (*Driver) Open(name string) (driver.Conn, error) {
@@ -32,34 +145,295 @@ func (*Driver) Open(name string) (driver.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newConn(c)
+	return newConn(context.Background(), c)
 }
 
-func parseDSN(name string) (config, error) {
-	re := regexp.MustCompile(`^((?P<username>[^:]+?)(:(?P<password>[^@]+?))?@)?(?P<hostname>[a-zA-Z0-9.\-]+?)(:(?P<port>\d+?))?/(?P<database>.+?)$`)
-	if !re.MatchString(name) {
-		return config{}, fmt.Errorf("Invalid DSN")
+// OpenConnector implements driver.DriverContext. The DSN is parsed once,
+// and connections made by the connector honor the context they are
+// made with.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	c, err := parseDSN(name)
+	if err != nil {
+		return nil, err
 	}
-	m := re.FindAllStringSubmatch(name, -1)[0]
-	n := re.SubexpNames()
+	return &connector{driver: d, config: c}, nil
+}
+
+// NewConfig returns a Config with the defaults a DSN without options
+// has: a connection to localhost on port 50000 in autocommit mode.
+func NewConfig() Config {
+	var c Config
+	c.setDefaults()
+	return c
+}
 
-	c := config{
-		Hostname: "localhost",
-		Port:     50000,
+// setDefaults sets the fields that are zero to their defaults.
+func (c *Config) setDefaults() {
+	if c.Hostname == "" {
+		c.Hostname = "localhost"
+	}
+	if c.Port == 0 {
+		c.Port = 50000
+	}
+	if c.Language == "" {
+		c.Language = "sql"
 	}
-	for i, v := range m {
-		if n[i] == "username" {
-			c.Username = v
-		} else if n[i] == "password" {
-			c.Password = v
-		} else if n[i] == "hostname" {
-			c.Hostname = v
-		} else if n[i] == "port" && v != "" {
-			c.Port, _ = strconv.Atoi(v)
-		} else if n[i] == "database" {
-			c.Database = v
+	if c.ApplicationName == "" {
+		c.ApplicationName = filepath.Base(os.Args[0])
+	}
+	if c.ConnectRetryInterval == 0 {
+		c.ConnectRetryInterval = time.Second
+	}
+}
+
+// NewConnector returns a connector that makes connections with c, for
+// use with sql.OpenDB. It lets a program set up connections without
+// writing their settings into a DSN. Fields of c that are zero get
+// their defaults.
+func NewConnector(c Config) driver.Connector {
+	c.setDefaults()
+	return &connector{driver: &Driver{}, config: c}
+}
+
+type connector struct {
+	driver *Driver
+	config Config
+}
+
+// Connect makes a connection. When ctx is done before the connection is
+// made, the attempt is given up and ctx.Err() is returned.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return newConn(ctx, c.config)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+func parseDSN(name string) (Config, error) {
+	// the DSN may be written as a URL
+	for _, scheme := range []string{"mapi:monetdb://", "monetdb://"} {
+		if strings.HasPrefix(name, scheme) {
+			name = name[len(scheme):]
+			break
 		}
 	}
 
-	return c, nil
+	query := ""
+	if i := strings.Index(name, "?"); i >= 0 {
+		name, query = name[:i], name[i+1:]
+	}
+
+	c := NewConfig()
+
+	// the credentials end at the last @ that is followed by the host
+	// and a /, so a database name may contain an @ as well
+	rest := name
+	at := strings.LastIndex(rest, "@")
+	for at >= 0 && !strings.Contains(rest[at:], "/") {
+		at = strings.LastIndex(rest[:at], "@")
+	}
+	if at >= 0 {
+		user, password := rest[:at], ""
+		if i := strings.Index(user, ":"); i >= 0 {
+			user, password = user[:i], user[i+1:]
+		}
+		if user == "" {
+			return Config{}, fmt.Errorf("Invalid DSN: missing username")
+		}
+
+		var err error
+		if c.Username, err = url.PathUnescape(user); err != nil {
+			return Config{}, fmt.Errorf("Invalid DSN: %v", err)
+		}
+		if c.Password, err = url.PathUnescape(password); err != nil {
+			return Config{}, fmt.Errorf("Invalid DSN: %v", err)
+		}
+		rest = rest[at+1:]
+	}
+
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return Config{}, fmt.Errorf("Invalid DSN: missing database name")
+	}
+	host, database := rest[:i], rest[i+1:]
+	if j := strings.LastIndex(host, ":"); j >= 0 {
+		port := host[j+1:]
+		host = host[:j]
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return Config{}, fmt.Errorf("Invalid DSN: invalid port: %s", port)
+		}
+		c.Port = p
+	}
+
+	if host == "" {
+		return Config{}, fmt.Errorf("Invalid DSN: missing hostname")
+	}
+	if !validHostname.MatchString(host) {
+		return Config{}, fmt.Errorf("Invalid DSN: invalid hostname: %s", host)
+	}
+	if database == "" {
+		return Config{}, fmt.Errorf("Invalid DSN: missing database name")
+	}
+	c.Hostname = host
+	c.Database = database
+
+	err := parseOptions(&c, query)
+	return c, err
+}
+
+// validHostname matches the host names and IPv4 addresses accepted in a
+// DSN.
+var validHostname = regexp.MustCompile(`^[a-zA-Z0-9.\-]+$`)
+
+// sessionVariable matches the names of session variables accepted in
+// set.<name> options.
+var sessionVariable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseOptions applies the options given in the query part of a DSN,
+// e.g. "?application_name=loader".
+func parseOptions(c *Config, query string) error {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("Invalid DSN options: %v", err)
+	}
+
+	for k, v := range values {
+		value := v[len(v)-1]
+		if name := strings.TrimPrefix(k, "set."); name != k {
+			if !sessionVariable.MatchString(name) {
+				return fmt.Errorf("Invalid session variable in DSN option: %s", k)
+			}
+			if c.Settings == nil {
+				c.Settings = make(map[string]string)
+			}
+			c.Settings[name] = value
+			continue
+		}
+
+		switch k {
+		case "language":
+			switch value {
+			case "sql", "mal":
+				c.Language = value
+			default:
+				err = fmt.Errorf("Invalid value for DSN option %s: %s", k, value)
+			}
+		case "application_name":
+			c.ApplicationName = value
+		case "client_remark":
+			c.ClientRemark = value
+		case "autocommit":
+			var on bool
+			on, err = parseBoolOption(k, value)
+			c.NoAutocommit = !on
+		case "trim_char":
+			c.TrimChar, err = parseBoolOption(k, value)
+		case "raw_strings":
+			c.RawStrings, err = parseBoolOption(k, value)
+		case "raw_values":
+			c.RawValues, err = parseBoolOption(k, value)
+		case "unknown_type":
+			switch value {
+			case "strict":
+				c.UnknownTypeRaw = false
+			case "raw":
+				c.UnknownTypeRaw = true
+			default:
+				err = fmt.Errorf("Invalid value for DSN option %s: %s", k, value)
+			}
+		case "decimal":
+			switch value {
+			case "float":
+				c.ExactDecimals = false
+			case "exact":
+				c.ExactDecimals = true
+			default:
+				err = fmt.Errorf("Invalid value for DSN option %s: %s", k, value)
+			}
+		case "qualified_names":
+			c.QualifiedNames, err = parseBoolOption(k, value)
+		case "statement_cache_size":
+			c.StatementCacheSize, err = parseIntOption(k, value)
+		case "timezone":
+			c.TimeZone, err = parseLocationOption(k, value)
+		case "loc":
+			c.Location, err = parseLocationOption(k, value)
+		case "force_utc":
+			c.ForceUTC, err = parseBoolOption(k, value)
+		case "readonly":
+			c.ReadOnly, err = parseBoolOption(k, value)
+		case "query_timeout":
+			c.QueryTimeout, err = parseDurationOption(k, value)
+		case "slow_query_threshold":
+			c.SlowQueryThreshold, err = parseDurationOption(k, value)
+		case "connect_retries":
+			c.ConnectRetries, err = parseIntOption(k, value)
+		case "connect_retry_interval":
+			c.ConnectRetryInterval, err = parseDurationOption(k, value)
+		case "max_rows":
+			c.MaxRows, err = parseIntOption(k, value)
+		case "max_statement_size":
+			c.MaxStatementSize, err = parseIntOption(k, value)
+		case "blocksize":
+			c.BlockSize, err = parseIntOption(k, value)
+			if err == nil && (c.BlockSize < 1 || c.BlockSize > mapi_MAX_BLOCK_SIZE) {
+				err = fmt.Errorf("Invalid value for DSN option %s: %s, must be between 1 and %d",
+					k, value, mapi_MAX_BLOCK_SIZE)
+			}
+		case "read_buffer":
+			c.ReadBuffer, err = parseIntOption(k, value)
+		case "write_buffer":
+			c.WriteBuffer, err = parseIntOption(k, value)
+		default:
+			return fmt.Errorf("Unknown DSN option: %s", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.ForceUTC && c.Location != nil {
+		return fmt.Errorf("Invalid DSN options: force_utc cannot be combined with loc")
+	}
+	return nil
+}
+
+// parseLocationOption parses a time zone name such as "Europe/Amsterdam"
+// or a UTC offset such as "+02:00".
+func parseLocationOption(name, value string) (*time.Location, error) {
+	if t, err := time.Parse("-07:00", value); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(value, offset), nil
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil || value == "" {
+		return nil, fmt.Errorf("Invalid time zone for DSN option %s: %s", name, value)
+	}
+	return loc, nil
+}
+
+func parseIntOption(name, value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("Invalid value for DSN option %s: %s", name, value)
+	}
+	return i, nil
+}
+
+func parseDurationOption(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid value for DSN option %s: %s", name, value)
+	}
+	return d, nil
+}
+
+func parseBoolOption(name, value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value for DSN option %s: %s", name, value)
+	}
+	return b, nil
 }
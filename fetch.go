@@ -0,0 +1,31 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+)
+
+type fetchSizeKey struct{}
+
+// WithFetchSize returns a context that makes the queries run with it
+// fetch their rows n at a time, instead of 100 at a time. With -1 all
+// rows are fetched with the query. It is meant for queries whose results
+// are known to be small, or large enough to be fetched in larger pages.
+//
+// The reply size of the session is set to n before the query, and back
+// to 100 after it, which takes a round trip each.
+func WithFetchSize(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, fetchSizeKey{}, n)
+}
+
+// fetchSizeFor returns the fetch size of ctx, or 0 if it has none.
+func fetchSizeFor(ctx context.Context) int {
+	n, _ := ctx.Value(fetchSizeKey{}).(int)
+	if n < -1 {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,95 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pagingServer answers queries with a result of n rows, of which it
+// sends as many as the reply size of the session allows, and the others
+// when they are exported.
+func pagingServer(cmds chan<- string, n int) func(*MapiConn) {
+	replySize := 100
+	tuples := func(from, to int) string {
+		var b strings.Builder
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&b, "[ %d\t]\n", i)
+		}
+		return b.String()
+	}
+	return serveCommands(func(cmd string) string {
+		cmds <- cmd
+		var offset, amount int
+		switch {
+		case strings.HasPrefix(cmd, "Xreply_size "):
+			fmt.Sscanf(cmd, "Xreply_size %d", &replySize)
+			return ""
+		case strings.HasPrefix(cmd, "Xexport "):
+			fmt.Sscanf(cmd, "Xexport 1 %d %d", &offset, &amount)
+			return fmt.Sprintf("&6 1 1 %d %d\n", amount, offset) + tuples(offset, offset+amount)
+		}
+		sent := n
+		if replySize >= 0 && replySize < n {
+			sent = replySize
+		}
+		return fmt.Sprintf("&1 1 %d 1 %d\n", n, sent) +
+			"% .t # table_name\n" +
+			"% i # name\n" +
+			"% int # type\n" +
+			"% 1 # length\n" +
+			tuples(0, sent)
+	})
+}
+
+func TestWithFetchSize(t *testing.T) {
+	cmds := make(chan string, 20)
+	srv := newFakeServer(t, pagingServer(cmds, 5))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	e := []int{0, 1, 2, 3, 4}
+	for _, n := range []int{-1, 2} {
+		rows, err := conn.QueryContext(WithFetchSize(context.Background(), n), "SELECT i FROM t")
+		if err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		var got []int
+		for rows.Next() {
+			var i int
+			if err := rows.Scan(&i); err != nil {
+				t.Fatalf("Error scanning: %v", err)
+			}
+			got = append(got, i)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("Error reading rows: %v", err)
+		}
+		rows.Close()
+		if !reflect.DeepEqual(got, e) {
+			t.Errorf("Invalid rows with fetch size %d: %v, expected: %v", n, got, e)
+		}
+	}
+
+	expectCommands(t, cmds,
+		"Xreply_size -1", "sSELECT i FROM t;", "Xreply_size 100",
+		"Xreply_size 2", "sSELECT i FROM t;", "Xreply_size 100",
+		"Xexport 1 2 2", "Xexport 1 4 1")
+}
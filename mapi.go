@@ -6,38 +6,66 @@ package monetdb
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	_ "crypto/md5"
 	_ "crypto/sha1"
+	_ "crypto/sha256"
 	_ "crypto/sha512"
+	"database/sql/driver"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	mapi_MAX_PACKAGE_LENGTH = (1024 * 8) - 2
 
-	mapi_MSG_PROMPT   = ""
-	mapi_MSG_INFO     = "#"
-	mapi_MSG_ERROR    = "!"
-	mapi_MSG_Q        = "&"
-	mapi_MSG_QTABLE   = "&1"
-	mapi_MSG_QUPDATE  = "&2"
-	mapi_MSG_QSCHEMA  = "&3"
-	mapi_MSG_QTRANS   = "&4"
-	mapi_MSG_QPREPARE = "&5"
-	mapi_MSG_QBLOCK   = "&6"
-	mapi_MSG_HEADER   = "%"
-	mapi_MSG_TUPLE    = "["
-	mapi_MSG_REDIRECT = "^"
-	mapi_MSG_OK       = "=OK"
+	// mapi_MAX_BLOCK_SIZE is the largest block the protocol allows, as
+	// the length is sent in the upper 15 bits of the block header.
+	mapi_MAX_BLOCK_SIZE = (1 << 15) - 1
+
+	// mapi_MAX_REDIRECTS is the number of redirects followed when
+	// connecting, to break redirect loops.
+	mapi_MAX_REDIRECTS = 10
+
+	mapi_MSG_PROMPT        = ""
+	mapi_MSG_INFO          = "#"
+	mapi_MSG_ERROR         = "!"
+	mapi_MSG_Q             = "&"
+	mapi_MSG_QTABLE        = "&1"
+	mapi_MSG_QUPDATE       = "&2"
+	mapi_MSG_QSCHEMA       = "&3"
+	mapi_MSG_QTRANS        = "&4"
+	mapi_MSG_QPREPARE      = "&5"
+	mapi_MSG_QBLOCK        = "&6"
+	mapi_MSG_HEADER        = "%"
+	mapi_MSG_TUPLE         = "["
+	mapi_MSG_TUPLE_NOSLICE = "=" // a single value, e.g. a line of EXPLAIN
+	mapi_MSG_REDIRECT      = "^"
+	mapi_MSG_OK            = "=OK"
 )
 
+// ErrTooManyConnections is returned by Connect when the server refuses
+// the connection because its maximum number of clients is reached.
+var ErrTooManyConnections = errors.New("Maximum number of client connections reached")
+
+// ErrAuthFailed is returned by Connect when the server rejects the
+// username or password. Connecting is not retried then.
+var ErrAuthFailed = errors.New("Authentication failed")
+
+// ErrProtocol is returned when the server sends data that does not follow
+// the MAPI protocol, such as a block shorter than its header promises.
+// The connection is closed when it occurs.
+var ErrProtocol = errors.New("MAPI protocol error")
+
 // MAPI connection is established.
 const MAPI_STATE_READY = 1
 
@@ -67,9 +95,35 @@ type MapiConn struct {
 
 	State int
 
-	conn *net.TCPConn
+	// BlockSize is the size of the blocks commands are sent in, at most
+	// 32767 bytes. Zero means the default of 8190 bytes. The size of the
+	// blocks received is up to the server.
+	BlockSize int
+
+	// ReadBuffer and WriteBuffer are the sizes of the receive and send
+	// buffers of the socket. Zero means the default of the system.
+	ReadBuffer  int
+	WriteBuffer int
+
+	conn net.Conn
+
+	// lastRead is when data was last read from the server, see isAlive.
+	lastRead time.Time
+
+	// options holds the optional fields of the server challenge,
+	// such as "sql=6" or "CLIENTINFO".
+	options map[string]string
 }
 
+// aliveCheckWait is how long isAlive waits for a read to time out where it
+// can't peek at the socket.
+const aliveCheckWait = time.Millisecond
+
+// idleCheckAfter is how long a connection has to be idle before isAlive
+// checks it. Servers close sessions after they have been idle for far
+// longer, so connections in steady use are not checked at all.
+var idleCheckAfter = time.Second
+
 // NewMapi returns a MonetDB's MAPI connection handle.
 //
 // To establish the connection, call the Connect() function.
@@ -96,23 +150,39 @@ func (c *MapiConn) Disconnect() {
 }
 
 // Cmd sends a MAPI command to MonetDB.
+//
+// If the connection is not usable, or breaks while the command is sent,
+// driver.ErrBadConn is returned and the command can safely be retried on
+// another connection. If it breaks while waiting for the response, the
+// command may have been executed and a different error is returned.
+// In both cases the connection is closed.
 func (c *MapiConn) Cmd(operation string) (string, error) {
 	if c.State != MAPI_STATE_READY {
-		return "", fmt.Errorf("Database not connected")
+		return "", driver.ErrBadConn
+	}
+
+	// A connection the server closed while it was idle would only
+	// fail after the command is sent, when it can no longer be told
+	// whether it ran, so an idle one is checked first.
+	if !c.isAlive() {
+		return "", driver.ErrBadConn
 	}
 
 	if err := c.putBlock([]byte(operation)); err != nil {
-		return "", err
+		c.Disconnect()
+		return "", driver.ErrBadConn
 	}
 
 	r, err := c.getBlock()
 	if err != nil {
-		return "", err
+		c.Disconnect()
+		return "", fmt.Errorf("Connection lost: %w", err)
 	}
 
 	resp := string(r)
 	if len(resp) == 0 {
-		return "", fmt.Errorf("Empty block response")
+		// an empty prompt, for commands without output
+		return "", nil
 
 	} else if strings.HasPrefix(resp, mapi_MSG_OK) {
 		return strings.TrimSpace(resp[3:]), nil
@@ -134,56 +204,184 @@ func (c *MapiConn) Cmd(operation string) (string, error) {
 
 // Connect starts a MAPI connection to MonetDB server.
 func (c *MapiConn) Connect() error {
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but gives up when ctx is done, in which
+// case ctx.Err() is returned. When the server redirects the client to
+// another server, as monetdbd does for the databases it proxies, the
+// redirect is followed.
+func (c *MapiConn) ConnectContext(ctx context.Context) error {
+	for redirects := 0; ; redirects++ {
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+
+		addr := fmt.Sprintf("%s:%d", c.Hostname, c.Port)
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetKeepAlive(false)
+			tcp.SetNoDelay(true)
+			if c.ReadBuffer > 0 {
+				tcp.SetReadBuffer(c.ReadBuffer)
+			}
+			if c.WriteBuffer > 0 {
+				tcp.SetWriteBuffer(c.WriteBuffer)
+			}
+		}
+		c.conn = conn
+
+		stop := c.watchContext(ctx)
+		redirect, err := c.login(ctx)
+		stop()
+		if err == nil && redirect != "" {
+			if redirects >= mapi_MAX_REDIRECTS {
+				err = fmt.Errorf("Maximal number of redirects reached (%d)", mapi_MAX_REDIRECTS)
+			} else {
+				err = c.redirect(redirect)
+			}
+		}
+		if err != nil {
+			c.Disconnect()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if redirect == "" {
+			return nil
+		}
 	}
+}
 
-	addr := fmt.Sprintf("%s:%d", c.Hostname, c.Port)
-	raddr, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return err
+// redirect points the connection at the server of a redirect of the form
+// mapi:monetdb://host:port/database.
+func (c *MapiConn) redirect(redirect string) error {
+	u, err := url.Parse(strings.TrimPrefix(redirect, "mapi:"))
+	if err != nil || u.Scheme != "monetdb" || u.Hostname() == "" {
+		return fmt.Errorf("Invalid redirect: %s", redirect)
 	}
 
-	conn, err := net.DialTCP("tcp", nil, raddr)
-	if err != nil {
-		return err
+	c.Hostname = u.Hostname()
+	if p := u.Port(); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("Invalid redirect: %s", redirect)
+		}
+		c.Port = port
 	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		c.Database = db
+	}
+	return nil
+}
 
-	conn.SetKeepAlive(false)
-	conn.SetNoDelay(true)
-	c.conn = conn
+// aLongTimeAgo is a deadline in the past, which makes pending reads and
+// writes on a connection return immediately.
+var aLongTimeAgo = time.Unix(1, 0)
 
-	err = c.login()
-	if err != nil {
-		return err
+// watchContext makes reads and writes on the connection give up when ctx
+// is done. The returned function stops watching and must be called before
+// the connection is used without ctx.
+func (c *MapiConn) watchContext(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
 	}
 
-	return nil
+	// The deadline is only set once ctx is done, so a read or write
+	// that gives up always finds ctx.Err set. A deadline of ctx set on
+	// the connection right away may pass before ctx notices.
+	conn := c.conn
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		conn.SetDeadline(time.Time{})
+	}
+}
+
+// isAlive reports whether the connection is still open, without a round
+// trip to the server. An idle connection has nothing to read, so anything
+// to read means the server hung up, or sent data nobody asked for. The
+// connection is closed then. A connection the server answered less than
+// idleCheckAfter ago is taken to be open.
+//
+// The socket is peeked at where the platform allows it. Elsewhere a read
+// is given aliveCheckWait to time out, as a read with a deadline that
+// passed already doesn't look at the socket at all.
+func (c *MapiConn) isAlive() bool {
+	if c.State != MAPI_STATE_READY || c.conn == nil {
+		return false
+	}
+	if time.Since(c.lastRead) < idleCheckAfter {
+		return true
+	}
+
+	alive, ok := peekAlive(c.conn)
+	if !ok {
+		c.conn.SetReadDeadline(time.Now().Add(aliveCheckWait))
+		var b [1]byte
+		_, err := c.conn.Read(b[:])
+		c.conn.SetReadDeadline(time.Time{})
+		ne, isNetErr := err.(net.Error)
+		alive = isNetErr && ne.Timeout()
+	}
+	if !alive {
+		c.Disconnect()
+	}
+	return alive
 }
 
-// login starts the login sequence
-func (c *MapiConn) login() error {
-	return c.tryLogin(0)
+// login starts the login sequence. If the server redirects the client to
+// another server, the redirect is returned.
+func (c *MapiConn) login(ctx context.Context) (string, error) {
+	return c.tryLogin(ctx, 0)
 }
 
 // tryLogin performs the login activity
-func (c *MapiConn) tryLogin(iteration int) error {
+func (c *MapiConn) tryLogin(ctx context.Context, iteration int) (string, error) {
 	challenge, err := c.getBlock()
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	// the server sends an error instead of a challenge when
+	// it refuses the connection
+	if msg := strings.TrimSpace(string(challenge)); strings.HasPrefix(msg, mapi_MSG_ERROR) {
+		if strings.Contains(msg, "maximum concurrent client limit reached") {
+			return "", fmt.Errorf("%w: %s", ErrTooManyConnections, msg[1:])
+		}
+		return "", fmt.Errorf("Database error: %s", msg[1:])
 	}
 
 	response, err := c.challengeResponse(challenge)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	c.putBlock([]byte(response))
+	if err := c.putBlock([]byte(response)); err != nil {
+		return "", err
+	}
 
 	bprompt, err := c.getBlock()
 	if err != nil {
-		return nil
+		return "", err
 	}
 
 	prompt := strings.TrimSpace(string(bprompt))
@@ -197,82 +395,123 @@ func (c *MapiConn) tryLogin(iteration int) error {
 		// TODO log info
 
 	} else if strings.HasPrefix(prompt, mapi_MSG_ERROR) {
-		// TODO log error
-		return fmt.Errorf("Database error: %s", prompt[1:])
+		msg := c.redact(prompt[1:])
+		if isAuthFailure(msg) {
+			return "", fmt.Errorf("%w: %s", ErrAuthFailed, msg)
+		}
+		return "", fmt.Errorf("Database error: %s", msg)
 
 	} else if strings.HasPrefix(prompt, mapi_MSG_REDIRECT) {
-		t := strings.Split(prompt, " ")
-		r := strings.Split(t[0][1:], ":")
-
-		if r[1] == "merovingian" {
-			// restart auth
-			if iteration <= 10 {
-				err = c.tryLogin(iteration + 1)
-				if err != nil {
-					return err
-				}
-			} else {
-				return fmt.Errorf("Maximal number of redirects reached (10)")
+		// the server may send several redirects, one per line,
+		// the first one is followed
+		redirect := strings.SplitN(prompt, "\n", 2)[0][1:]
+
+		if strings.HasPrefix(redirect, "mapi:merovingian:") {
+			// monetdbd proxies the connection, and the login is
+			// restarted on the same socket
+			if iteration >= mapi_MAX_REDIRECTS {
+				return "", fmt.Errorf("Maximal number of redirects reached (%d)", mapi_MAX_REDIRECTS)
 			}
+			return c.tryLogin(ctx, iteration+1)
 
-		} else if r[1] == "monetdb" {
-			c.Hostname = r[2][2:]
-			t = strings.Split(r[3], "/")
-			port, _ := strconv.ParseInt(t[0], 10, 32)
-			c.Port = int(port)
-			c.Database = t[1]
-			c.conn.Close()
-			c.Connect()
+		} else if strings.HasPrefix(redirect, "mapi:monetdb:") {
+			return redirect, nil
 
 		} else {
-			return fmt.Errorf("Unknown redirect: %s", prompt)
+			return "", fmt.Errorf("Unknown redirect: %s", prompt)
 		}
 	} else {
-		return fmt.Errorf("Unknown state: %s", prompt)
+		return "", fmt.Errorf("Unknown state: %s", prompt)
 	}
 
 	c.State = MAPI_STATE_READY
 
-	return nil
+	return "", nil
+}
+
+// isAuthFailure reports whether a login error of the server is about the
+// credentials, as in "InvalidCredentialsException:checkCredentials:invalid
+// credentials for user 'monetdb'".
+func isAuthFailure(msg string) bool {
+	return strings.Contains(msg, "InvalidCredentialsException") ||
+		strings.Contains(strings.ToLower(msg), "invalid credentials")
+}
+
+// redact hides the password in a message of the server.
+func (c *MapiConn) redact(msg string) string {
+	if c.Password == "" {
+		return msg
+	}
+	return strings.ReplaceAll(msg, c.Password, "***")
 }
 
-// challengeResponse produces a response given a challenge
+// hashAlgorithms are the hash functions the server may ask for, by their
+// name in the challenge, strongest first.
+var hashAlgorithms = []struct {
+	name string
+	hash crypto.Hash
+}{
+	{"SHA512", crypto.SHA512},
+	{"SHA384", crypto.SHA384},
+	{"SHA256", crypto.SHA256},
+	{"SHA224", crypto.SHA224},
+	{"SHA1", crypto.SHA1},
+	{"MD5", crypto.MD5},
+}
+
+// challengeResponse produces a response given a challenge of the form
+// salt:backend:protocol:hashes:endianness:algorithm[:options...]
 func (c *MapiConn) challengeResponse(challenge []byte) (string, error) {
-	t := strings.Split(string(challenge), ":")
+	t := strings.Split(strings.TrimSpace(string(challenge)), ":")
+	// older protocols have a different layout
+	if len(t) > 2 && t[2] != "9" {
+		return "", fmt.Errorf("Unsupported protocol version: %s, only 9 is supported", t[2])
+	}
+	if len(t) < 6 {
+		return "", fmt.Errorf("%w: challenge has %d fields, expected at least 6", ErrProtocol, len(t))
+	}
 	salt := t[0]
-	protocol := t[2]
 	hashes := t[3]
+	endianness := t[4]
 	algo := t[5]
+	if endianness != "LIT" && endianness != "BIG" {
+		return "", fmt.Errorf("Unsupported byte order: %s", endianness)
+	}
 
-	if protocol != "9" {
-		return "", fmt.Errorf("We only speak protocol v9")
+	c.options = make(map[string]string)
+	for _, o := range t[6:] {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) == 2 {
+			c.options[kv[0]] = kv[1]
+		} else if o != "" {
+			c.options[o] = ""
+		}
 	}
 
 	var h hash.Hash
-	if algo == "SHA512" {
-		h = crypto.SHA512.New()
-	} else {
-		// TODO support more algorithm
-		return "", fmt.Errorf("Unsupported algorithm: %s", algo)
+	for _, a := range hashAlgorithms {
+		if a.name == algo {
+			h = a.hash.New()
+		}
+	}
+	if h == nil {
+		return "", fmt.Errorf("Unsupported password hash algorithm: %s", algo)
 	}
 	io.WriteString(h, c.Password)
 	p := fmt.Sprintf("%x", h.Sum(nil))
 
 	shashes := "," + hashes + ","
 	var pwhash string
-	if strings.Contains(shashes, ",SHA1,") {
-		h = crypto.SHA1.New()
-		io.WriteString(h, p)
-		io.WriteString(h, salt)
-		pwhash = fmt.Sprintf("{SHA1}%x", h.Sum(nil))
-
-	} else if strings.Contains(shashes, ",MD5,") {
-		h = crypto.MD5.New()
-		io.WriteString(h, p)
-		io.WriteString(h, salt)
-		pwhash = fmt.Sprintf("{MD5}%x", h.Sum(nil))
-
-	} else {
+	for _, a := range hashAlgorithms {
+		if strings.Contains(shashes, ","+a.name+",") {
+			h = a.hash.New()
+			io.WriteString(h, p)
+			io.WriteString(h, salt)
+			pwhash = fmt.Sprintf("{%s}%x", a.name, h.Sum(nil))
+			break
+		}
+	}
+	if pwhash == "" {
 		return "", fmt.Errorf("Unsupported hash algorithm required for login %s", hashes)
 	}
 
@@ -280,22 +519,45 @@ func (c *MapiConn) challengeResponse(challenge []byte) (string, error) {
 	return r, nil
 }
 
+// hasOption reports whether the server announced the given option
+// in its challenge.
+func (c *MapiConn) hasOption(name string) bool {
+	_, ok := c.options[name]
+	return ok
+}
+
 // getBlock retrieves a block of message
 func (c *MapiConn) getBlock() ([]byte, error) {
 	r := new(bytes.Buffer)
+	err := c.readMessage(func(d []byte) error {
+		r.Write(d)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.Bytes(), nil
+}
 
+// readMessage reads a message, calling fn with the data of each of its
+// blocks, so a large message need not be held in memory. An error of fn
+// ends the reading, leaving the rest of the message unread.
+func (c *MapiConn) readMessage(fn func([]byte) error) error {
 	last := 0
 	for last != 1 {
 		flag, err := c.getBytes(2)
 		if err != nil {
-			return nil, err
+			if len(flag) > 0 {
+				return fmt.Errorf("%w: truncated block header: %v", ErrProtocol, err)
+			}
+			return err
 		}
 
 		var unpacked uint16
 		buf := bytes.NewBuffer(flag)
 		err = binary.Read(buf, binary.LittleEndian, &unpacked)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		length := unpacked >> 1
@@ -303,16 +565,62 @@ func (c *MapiConn) getBlock() ([]byte, error) {
 
 		d, err := c.getBytes(int(length))
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("%w: block truncated after %d of %d bytes: %v",
+				ErrProtocol, len(d), length, err)
 		}
 
-		r.Write(d)
+		if err := fn(d); err != nil {
+			return err
+		}
 	}
 
-	return r.Bytes(), nil
+	return nil
+}
+
+// messageReader reads the data of a message as it arrives, block by block,
+// so a large message need not be held in memory. It returns io.EOF at the
+// end of the message.
+type messageReader struct {
+	c *MapiConn
+	// remaining is the number of bytes of the current block not read
+	// yet, and last whether it is the last block of the message.
+	remaining int
+	last      bool
 }
 
-// getBytes reads the given amount of bytes
+func (r *messageReader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		flag, err := r.c.getBytes(2)
+		if err != nil {
+			if len(flag) > 0 || err == io.EOF {
+				return 0, fmt.Errorf("%w: truncated block header: %v", ErrProtocol, err)
+			}
+			return 0, err
+		}
+		unpacked := binary.LittleEndian.Uint16(flag)
+		r.remaining = int(unpacked >> 1)
+		r.last = unpacked&1 == 1
+	}
+
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.c.conn.Read(p)
+	r.remaining -= n
+	if err == io.EOF && r.remaining > 0 {
+		return n, fmt.Errorf("%w: block truncated, %d bytes missing", ErrProtocol, r.remaining)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// getBytes reads the given amount of bytes. On error it returns the bytes
+// read so far.
 func (c *MapiConn) getBytes(count int) ([]byte, error) {
 	r := make([]byte, count)
 	b := make([]byte, count)
@@ -320,43 +628,53 @@ func (c *MapiConn) getBytes(count int) ([]byte, error) {
 	read := 0
 	for read < count {
 		n, err := c.conn.Read(b)
-		if err != nil {
-			return nil, err
-		}
 		copy(r[read:], b[:n])
 		read += n
+		if err != nil && read < count {
+			return r[:read], err
+		}
 		// shrink buffer size to the len of remaining data
 		// in case it reads more than what it needs in next read but doesn't process the extra data
 		b = b[:count-read]
 	}
 
+	c.lastRead = time.Now()
 	return r, nil
 }
 
 // putBlock sends the given data as one or more blocks
 func (c *MapiConn) putBlock(b []byte) error {
+	size := c.BlockSize
+	if size <= 0 || size > mapi_MAX_BLOCK_SIZE {
+		size = mapi_MAX_PACKAGE_LENGTH
+	}
+
+	// A message is sent as blocks of size bytes, each with a header
+	// holding its length and whether it is the last one. A message of a
+	// multiple of size bytes ends with an empty block. The header and
+	// the data of a block are written at once, so the buffer only needs
+	// to hold the largest block, which is smaller than size for a short
+	// message.
+	n := size
+	if len(b) < n {
+		n = len(b)
+	}
+	block := make([]byte, 2+n)
 	pos := 0
 	last := 0
 	for last != 1 {
-		end := pos + mapi_MAX_PACKAGE_LENGTH
+		end := pos + size
 		if end > len(b) {
 			end = len(b)
 		}
-		data := b[pos:end]
-		length := len(data)
-		if length < mapi_MAX_PACKAGE_LENGTH {
+		length := end - pos
+		if length < size {
 			last = 1
 		}
 
-		var packed uint16
-		packed = uint16((length << 1) + last)
-		flag := new(bytes.Buffer)
-		binary.Write(flag, binary.LittleEndian, packed)
-
-		if _, err := c.conn.Write(flag.Bytes()); err != nil {
-			return err
-		}
-		if _, err := c.conn.Write(data); err != nil {
+		binary.LittleEndian.PutUint16(block, uint16((length<<1)+last))
+		copy(block[2:], b[pos:end])
+		if _, err := c.conn.Write(block[:2+length]); err != nil {
 			return err
 		}
 
@@ -0,0 +1,648 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const fakeChallenge = "s4lt:monetdb:9:SHA1,MD5:LIT:SHA512:"
+
+// fakeServer is a minimal MAPI server that answers with canned responses.
+// Every accepted connection is passed to the handler, which talks to the
+// client through the server side of a MapiConn.
+type fakeServer struct {
+	listener *net.TCPListener
+	handler  func(*MapiConn)
+}
+
+func newFakeServer(t testing.TB, handler func(*MapiConn)) *fakeServer {
+	return newFakeServerAt(t, "127.0.0.1:0", handler)
+}
+
+// newFakeServerAt is like newFakeServer, but listens on the given address.
+func newFakeServerAt(t testing.TB, address string, handler func(*MapiConn)) *fakeServer {
+	addr, _ := net.ResolveTCPAddr("tcp", address)
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+
+	s := &fakeServer{
+		listener: l,
+		handler:  handler,
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go func() {
+			m := &MapiConn{conn: c, State: MAPI_STATE_READY}
+			s.handler(m)
+			c.Close()
+		}()
+	}
+}
+
+func (s *fakeServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeServer) dsn() string {
+	return fmt.Sprintf("127.0.0.1:%d/testdb", s.port())
+}
+
+// handshake performs the server side of a successful login and returns
+// the login response sent by the client.
+func handshake(m *MapiConn) (string, error) {
+	return handshakeChallenge(m, fakeChallenge)
+}
+
+// handshakeChallenge is like handshake, but sends the given challenge.
+func handshakeChallenge(m *MapiConn, challenge string) (string, error) {
+	if err := m.putBlock([]byte(challenge)); err != nil {
+		return "", err
+	}
+	r, err := m.getBlock()
+	if err != nil {
+		return "", err
+	}
+	return string(r), m.putBlock([]byte(""))
+}
+
+// serveCommands returns a handler that logs in the client and answers
+// every command with the response returned by respond.
+func serveCommands(respond func(cmd string) string) func(*MapiConn) {
+	return func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		for {
+			b, err := m.getBlock()
+			if err != nil {
+				return
+			}
+			if err := m.putBlock([]byte(respond(string(b)))); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// recordCommands returns a handler that answers every command with
+// response and sends the received commands to cmds.
+func recordCommands(cmds chan<- string, response string) func(*MapiConn) {
+	return serveCommands(func(cmd string) string {
+		cmds <- cmd
+		return response
+	})
+}
+
+func TestConnectHandshake(t *testing.T) {
+	login := make(chan string, 1)
+	srv := newFakeServer(t, func(m *MapiConn) {
+		r, _ := handshake(m)
+		login <- r
+	})
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer m.Disconnect()
+
+	if m.State != MAPI_STATE_READY {
+		t.Errorf("Invalid state: %d, expected: %d", m.State, MAPI_STATE_READY)
+	}
+	r := <-login
+	e := "BIG:me:{SHA1}"
+	if r[:len(e)] != e {
+		t.Errorf("Invalid login response: %s, expected prefix: %s", r, e)
+	}
+}
+
+func TestConnectAuthFailed(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		m.putBlock([]byte(fakeChallenge))
+		m.getBlock()
+		m.putBlock([]byte("!InvalidCredentialsException:checkCredentials:invalid credentials for user 'me' (s3cret)\n"))
+	})
+	defer srv.Close()
+
+	_, err := (&Driver{}).Open("me:s3cret@" + srv.dsn())
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Invalid error: %v, expected: %v", err, ErrAuthFailed)
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("Password not redacted: %v", err)
+	}
+}
+
+func TestConnectTooManyConnections(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		m.putBlock([]byte("!maximum concurrent client limit reached (64), please try again later\n"))
+	})
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	err := m.Connect()
+	if !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("Invalid error: %v, expected: %v", err, ErrTooManyConnections)
+	}
+	if m.conn != nil || m.State != MAPI_STATE_INIT {
+		t.Errorf("Connection not torn down after refused login")
+	}
+}
+
+func TestCmdBadConn(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		handshake(m)
+		// receive one command and hang up without answering
+		m.getBlock()
+	})
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	_, err := m.Cmd("sSELECT 1;")
+	if err == nil || errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("Invalid error: %v, expected a lost connection", err)
+	}
+	if m.State != MAPI_STATE_INIT {
+		t.Errorf("Connection not closed after it was lost")
+	}
+
+	if _, err := m.Cmd("sSELECT 1;"); err != driver.ErrBadConn {
+		t.Errorf("Invalid error: %v, expected: %v", err, driver.ErrBadConn)
+	}
+}
+
+func TestTruncatedBlock(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		handshake(m)
+		m.getBlock()
+		// a final block of 100 bytes, of which only 10 arrive
+		m.conn.Write([]byte{100<<1 | 1, 0})
+		m.conn.Write([]byte("&1 0 1 1 1"))
+	})
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	_, err := m.Cmd("sSELECT 1;")
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("Invalid error: %v, expected: %v", err, ErrProtocol)
+	}
+	if m.State != MAPI_STATE_INIT {
+		t.Errorf("Connection not closed after a truncated block")
+	}
+}
+
+func TestChallengeResponse(t *testing.T) {
+	type tc struct {
+		challenge string
+		prefix    string
+		err       string
+	}
+	tcs := []tc{
+		tc{"s4lt:merovingian:9:RIPEMD160,SHA512,SHA384,SHA256,SHA224,SHA1:LIT:SHA512:" +
+			"sql=6:BINARY=1:OOBINTR=1:CLIENTINFO:NEWFEATURE=2:\n", "BIG:me:{SHA512}", ""},
+		tc{"s4lt:mserver:9:SHA256,MD5:BIG:SHA256:", "BIG:me:{SHA256}", ""},
+		tc{"s4lt:monetdb:9:SHA1,MD5:LIT:SHA512:", "BIG:me:{SHA1}", ""},
+		tc{"s4lt:mserver:8:SHA1,MD5:LIT", "", "Unsupported protocol version: 8"},
+		tc{"s4lt:mserver:9:SHA1:MID:SHA512:", "", "Unsupported byte order: MID"},
+		tc{"s4lt:mserver:9:SHA1:LIT:WHIRLPOOL:", "", "Unsupported password hash algorithm: WHIRLPOOL"},
+		tc{"s4lt:mserver:9:CRC32:LIT:SHA512:", "", "Unsupported hash algorithm required for login CRC32"},
+		tc{"s4lt:mserver", "", "challenge has 2 fields"},
+	}
+
+	for _, c := range tcs {
+		m := NewMapi("127.0.0.1", 50000, "me", "secret", "testdb", "sql")
+		r, err := m.challengeResponse([]byte(c.challenge))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Invalid error for %s: %v, expected: %s", c.challenge, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error responding to %s: %v", c.challenge, err)
+		} else if !strings.HasPrefix(r, c.prefix) || !strings.HasSuffix(r, ":sql:testdb:") {
+			t.Errorf("Invalid response to %s: %s, expected prefix: %s", c.challenge, r, c.prefix)
+		}
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	cmds := make(chan string, 1)
+	srv := newFakeServer(t, recordCommands(cmds, ""))
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	m.BlockSize = 100
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer m.Disconnect()
+
+	for _, n := range []int{99, 100, 101, 10000} {
+		cmd := "s" + strings.Repeat("x", n-1)
+		if _, err := m.Cmd(cmd); err != nil {
+			t.Fatalf("Error sending command: %v", err)
+		}
+		if c := <-cmds; c != cmd {
+			t.Errorf("Invalid command of %d bytes received: %d bytes", n, len(c))
+		}
+	}
+}
+
+// TestLargeStatement checks the blocks a statement larger than a block is
+// sent in, as the server reads them.
+func TestLargeStatement(t *testing.T) {
+	type block struct {
+		length int
+		last   bool
+	}
+	blocks := make(chan []block, 1)
+	received := make(chan string, 1)
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		var bs []block
+		var msg []byte
+		for {
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(m.conn, header); err != nil {
+				return
+			}
+			flag := binary.LittleEndian.Uint16(header)
+			b := block{int(flag >> 1), flag&1 == 1}
+			data := make([]byte, b.length)
+			if _, err := io.ReadFull(m.conn, data); err != nil {
+				return
+			}
+			bs = append(bs, b)
+			msg = append(msg, data...)
+			if b.last {
+				break
+			}
+		}
+		blocks <- bs
+		received <- string(msg)
+		m.putBlock([]byte("&2 1 -1\n"))
+	})
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	value := strings.Repeat("x", 3*mapi_MAX_PACKAGE_LENGTH)
+	if _, err := db.Exec("INSERT INTO t VALUES (?)", value); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	cmd := "sINSERT INTO t VALUES ('" + value + "');"
+	if r := <-received; r != cmd {
+		t.Errorf("Invalid statement received: %d bytes, expected %d bytes", len(r), len(cmd))
+	}
+	bs := <-blocks
+	if len(bs) != 4 {
+		t.Fatalf("Invalid number of blocks: %d, expected 4", len(bs))
+	}
+	for i, b := range bs[:3] {
+		if b.length != mapi_MAX_PACKAGE_LENGTH || b.last {
+			t.Errorf("Invalid block %d: %d bytes, last: %v", i, b.length, b.last)
+		}
+	}
+	if b := bs[3]; b.length != len(cmd)-3*mapi_MAX_PACKAGE_LENGTH || !b.last {
+		t.Errorf("Invalid last block: %d bytes, last: %v", b.length, b.last)
+	}
+}
+
+func benchmarkBlockSize(b *testing.B, size int) {
+	result := "&1 0 1 1 1\n% .t # table_name\n% v # name\n% clob # type\n% 0 # length\n" +
+		"[ \"" + strings.Repeat("x", 1<<20) + "\"\t]\n"
+	srv := newFakeServer(b, func(m *MapiConn) {
+		m.BlockSize = size
+		serveCommands(func(cmd string) string {
+			return result
+		})(m)
+	})
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	m.BlockSize = size
+	if err := m.Connect(); err != nil {
+		b.Fatalf("Error connecting: %v", err)
+	}
+	defer m.Disconnect()
+
+	cmd := "sINSERT INTO t VALUES ('" + strings.Repeat("x", 1<<20) + "');"
+	b.SetBytes(int64(len(cmd) + len(result)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Cmd(cmd); err != nil {
+			b.Fatalf("Error sending command: %v", err)
+		}
+	}
+}
+
+func BenchmarkBlockSizeDefault(b *testing.B) {
+	benchmarkBlockSize(b, 0)
+}
+
+func BenchmarkBlockSizeMax(b *testing.B) {
+	benchmarkBlockSize(b, mapi_MAX_BLOCK_SIZE)
+}
+
+// streamingServer answers every query with a result of n rows, sent
+// with it.
+func streamingServer(tb testing.TB, n int) *fakeServer {
+	var b strings.Builder
+	fmt.Fprintf(&b, "&1 0 %d 2 %d\n", n, n)
+	b.WriteString("% .t,\t.t # table_name\n% i,\ts # name\n% int,\tvarchar # type\n% 1,\t40 # length\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "[ %d,\t\"row %d of a result streamed in blocks\"\t]\n", i, i)
+	}
+	result := b.String()
+	return newFakeServer(tb, serveCommands(func(cmd string) string {
+		return result
+	}))
+}
+
+// readStream queries a streamingServer and checks every row.
+func readStream(db *sql.DB, n int) error {
+	rows, err := db.Query("SELECT i, s FROM t")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	count := 0
+	for ; rows.Next(); count++ {
+		var i int
+		var s string
+		if err := rows.Scan(&i, &s); err != nil {
+			return err
+		}
+		if e := fmt.Sprintf("row %d of a result streamed in blocks", count); i != count || s != e {
+			return fmt.Errorf("Invalid row %d: %d, %q", count, i, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if count != n {
+		return fmt.Errorf("Invalid number of rows: %d, expected: %d", count, n)
+	}
+	return nil
+}
+
+func TestSocketBuffers(t *testing.T) {
+	srv := streamingServer(t, 10000)
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?read_buffer=65536&write_buffer=65536")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if err := readStream(db, 10000); err != nil {
+		t.Error(err)
+	}
+}
+
+func benchmarkReadBuffer(b *testing.B, size int) {
+	srv := streamingServer(b, 10000)
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", fmt.Sprintf("%s?read_buffer=%d", srv.dsn(), size))
+	if err != nil {
+		b.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := readStream(db, 10000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadBufferDefault(b *testing.B) {
+	benchmarkReadBuffer(b, 0)
+}
+
+func BenchmarkReadBufferSmall(b *testing.B) {
+	benchmarkReadBuffer(b, 64<<10)
+}
+
+func BenchmarkReadBufferLarge(b *testing.B) {
+	benchmarkReadBuffer(b, 4<<20)
+}
+
+// redirectHandshake performs the server side of a login that redirects
+// the client.
+func redirectHandshake(m *MapiConn, redirect string) error {
+	if err := m.putBlock([]byte(fakeChallenge)); err != nil {
+		return err
+	}
+	if _, err := m.getBlock(); err != nil {
+		return err
+	}
+	return m.putBlock([]byte("^" + redirect + "\n"))
+}
+
+func TestConnectRedirect(t *testing.T) {
+	cmds := make(chan string, 1)
+	target := newFakeServer(t, recordCommands(cmds, ""))
+	defer target.Close()
+
+	redirect := fmt.Sprintf("mapi:monetdb://127.0.0.1:%d/otherdb", target.port())
+	proxy := newFakeServer(t, func(m *MapiConn) {
+		redirectHandshake(m, redirect)
+	})
+	defer proxy.Close()
+
+	m := NewMapi("127.0.0.1", proxy.port(), "me", "secret", "testdb", "sql")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer m.Disconnect()
+
+	if m.Port != target.port() || m.Database != "otherdb" {
+		t.Errorf("Redirect not followed: %d/%s, expected: %d/%s",
+			m.Port, m.Database, target.port(), "otherdb")
+	}
+	if _, err := m.Cmd("sSELECT 1;"); err != nil {
+		t.Fatalf("Error sending command: %v", err)
+	}
+	if c := <-cmds; c != "sSELECT 1;" {
+		t.Errorf("Invalid command: %s, expected: %s", c, "sSELECT 1;")
+	}
+}
+
+func TestConnectMerovingianRedirect(t *testing.T) {
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if err := redirectHandshake(m, "mapi:merovingian://proxy?database=testdb"); err != nil {
+			return
+		}
+		serveCommands(func(cmd string) string {
+			return ""
+		})(m)
+	})
+	defer srv.Close()
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer m.Disconnect()
+
+	if m.State != MAPI_STATE_READY {
+		t.Errorf("Invalid state: %d, expected: %d", m.State, MAPI_STATE_READY)
+	}
+}
+
+func TestConnectRedirectLoop(t *testing.T) {
+	var port int32
+	srv := newFakeServer(t, func(m *MapiConn) {
+		redirectHandshake(m, fmt.Sprintf("mapi:monetdb://127.0.0.1:%d/testdb", atomic.LoadInt32(&port)))
+	})
+	defer srv.Close()
+	atomic.StoreInt32(&port, int32(srv.port()))
+
+	m := NewMapi("127.0.0.1", srv.port(), "me", "secret", "testdb", "sql")
+	err := m.Connect()
+	if err == nil || !strings.Contains(err.Error(), "Maximal number of redirects") {
+		t.Errorf("Invalid error: %v, expected: Maximal number of redirects reached", err)
+	}
+	if m.State != MAPI_STATE_INIT {
+		t.Errorf("Connection not closed after a redirect loop")
+	}
+}
+
+func TestMultiBlockResponse(t *testing.T) {
+	response := "&1 0 2 1 2\n" +
+		"% .t # table_name\n" +
+		"% s # name\n" +
+		"% varchar # type\n" +
+		"% 5 # length\n" +
+		"[ \"hello\"\t]\n" +
+		"[ \"world\"\t]\n"
+	srv := newFakeServer(t, func(m *MapiConn) {
+		handshake(m)
+		m.getBlock()
+		// split the response in the middle of a header and of a row
+		parts := []string{response[:20], response[20 : len(response)-10], response[len(response)-10:]}
+		for i, p := range parts {
+			last := 0
+			if i == len(parts)-1 {
+				last = 1
+			}
+			h := len(p)<<1 | last
+			m.conn.Write([]byte{byte(h), byte(h >> 8)})
+			m.conn.Write([]byte(p))
+		}
+	})
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	s := newStmt(c.(*Conn), "SELECT s FROM t")
+	rows, err := s.Query(nil)
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	dest := make([]driver.Value, 1)
+	var values []string
+	for rows.Next(dest) == nil {
+		values = append(values, string(dest[0].([]byte)))
+	}
+	if strings.Join(values, ",") != "hello,world" {
+		t.Errorf("Invalid values: %v, expected: [hello world]", values)
+	}
+}
+
+// checkAlways makes isAlive check connections however recently the server
+// answered them, for tests of connections closed right after a query. The
+// function returned restores the threshold.
+func checkAlways() func() {
+	d := idleCheckAfter
+	idleCheckAfter = 0
+	return func() { idleCheckAfter = d }
+}
+
+func TestIsAliveBusy(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	m := &MapiConn{conn: client, State: MAPI_STATE_READY, lastRead: time.Now()}
+
+	// a connection in steady use is not checked, so the hang-up is only
+	// seen once it has been idle
+	server.Close()
+	if !m.isAlive() {
+		t.Errorf("Connection used just now checked")
+	}
+	m.lastRead = time.Now().Add(-idleCheckAfter)
+	if m.isAlive() {
+		t.Errorf("Idle connection the server closed reported as alive")
+	}
+}
+
+func TestIsAliveDeadline(t *testing.T) {
+	// a pipe has no socket to peek at, so isAlive reads with a deadline
+	client, server := net.Pipe()
+	defer client.Close()
+	m := &MapiConn{conn: client, State: MAPI_STATE_READY}
+
+	if !m.isAlive() {
+		t.Errorf("Idle connection reported as closed")
+	}
+	server.Close()
+	if m.isAlive() {
+		t.Errorf("Connection the server closed reported as alive")
+	}
+	if m.State != MAPI_STATE_INIT {
+		t.Errorf("Invalid state: %d, expected: %d", m.State, MAPI_STATE_INIT)
+	}
+}
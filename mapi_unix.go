@@ -0,0 +1,46 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"net"
+	"syscall"
+)
+
+// peekAlive reports whether conn is still open by peeking at its socket,
+// which does not wait for the server the way a read with a deadline does.
+// It returns false for ok if conn has no socket to look at.
+func peekAlive(conn net.Conn) (alive bool, ok bool) {
+	if tc, isTLS := conn.(interface{ NetConn() net.Conn }); isTLS {
+		conn = tc.NetConn()
+	}
+	sc, isSocket := conn.(syscall.Conn)
+	if !isSocket {
+		return false, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+
+	var n int
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, _, rerr = syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		return true
+	})
+	if err != nil {
+		// closed on this side
+		return false, true
+	}
+	// an idle connection has nothing to read; the end of the stream or
+	// data nobody asked for means it is no longer usable
+	alive = n <= 0 && (rerr == syscall.EAGAIN || rerr == syscall.EWOULDBLOCK)
+	return alive, true
+}
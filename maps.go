@@ -0,0 +1,120 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// QueryMaps runs a query and returns its rows as maps from column name to
+// value, for queries whose columns are not known in advance. Values have
+// the type the driver converts their column to, text is returned as a
+// string and NULL as nil. Of columns with the same name, the last one
+// wins.
+func QueryMaps(db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	values := make([]interface{}, len(types))
+	dest := make([]interface{}, len(types))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		m := make(map[string]interface{}, len(types))
+		for i, t := range types {
+			v := values[i]
+			// text is passed to database/sql as bytes
+			if b, ok := v.([]byte); ok && t.DatabaseTypeName() != "BLOB" {
+				v = string(b)
+			}
+			m[t.Name()] = v
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// RowToJSON returns the current row of rows, after a call to Next, as a
+// JSON object from column name to value, in the order of the columns.
+// Numbers are written as JSON numbers, dates, times and timestamps as ISO
+// 8601 strings, blobs as base64 strings, JSON values as they are and NULL
+// as null.
+func RowToJSON(rows *sql.Rows) ([]byte, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(types))
+	dest := make([]interface{}, len(types))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, t := range types {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(t.Name())
+		b.Write(name)
+		b.WriteByte(':')
+
+		v, err := jsonValue(t.DatabaseTypeName(), values[i])
+		if err != nil {
+			return nil, fmt.Errorf("Cannot write column %s as JSON: %v", t.Name(), err)
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// jsonValue returns a value scanned from a column of the given type as
+// JSON.
+func jsonValue(dbType string, v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		switch dbType {
+		case "BLOB":
+			return json.Marshal(val)
+		case "JSON", "DECIMAL":
+			// JSON values and decimals returned as text are
+			// written as they are
+			if json.Valid(val) {
+				return val, nil
+			}
+		}
+		return json.Marshal(string(val))
+	case Date:
+		return json.Marshal(val.String())
+	case Time:
+		return json.Marshal(val.String())
+	case TimeTZ:
+		return json.Marshal(val.String())
+	}
+	return json.Marshal(v)
+}
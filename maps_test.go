@@ -0,0 +1,82 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestQueryMaps(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 4 2\n" +
+			"% .t,\t.t,\t.t,\t.t # table_name\n" +
+			"% id,\tname,\tscore,\tdata # name\n" +
+			"% int,\tvarchar,\tdouble,\tblob # type\n" +
+			"% 1,\t5,\t24,\t4 # length\n" +
+			"[ 1,\t\"alice\",\t2.5,\tCAFE\t]\n" +
+			"[ 2,\tNULL,\tNULL,\tNULL\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	m, err := QueryMaps(db, "SELECT id, name, score, data FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+
+	e := []map[string]interface{}{
+		map[string]interface{}{"id": int32(1), "name": "alice", "score": 2.5, "data": []byte{0xca, 0xfe}},
+		map[string]interface{}{"id": int32(2), "name": nil, "score": nil, "data": nil},
+	}
+	if !reflect.DeepEqual(m, e) {
+		t.Errorf("Invalid rows: %v, expected: %v", m, e)
+	}
+}
+
+func TestRowToJSON(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 9 1\n" +
+			"% .t,\t.t,\t.t,\t.t,\t.t,\t.t,\t.t,\t.t,\t.t # table_name\n" +
+			"% id,\tname,\tprice,\tday,\tat,\tstarts,\tdata,\tdoc,\tok # name\n" +
+			"% int,\tvarchar,\tdecimal,\tdate,\ttimestamp,\ttime,\tblob,\tjson,\tboolean # type\n" +
+			"% 1,\t5,\t5,\t10,\t26,\t8,\t4,\t9,\t5 # length\n" +
+			"% 32 0,\t5 0,\t10 2,\t0 0,\t7 0,\t1 0,\t0 0,\t0 0,\t1 0 # typesizes\n" +
+			"[ 1,\t\"a \\\"b\\\"\",\t12.50,\t2020-01-02,\t2020-01-02 10:20:30.500000,\t10:20:30,\tCAFE,\t\"{\\\"k\\\": [1, 2]}\",\tNULL\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		t.Fatalf("No row returned: %v", rows.Err())
+	}
+	b, err := RowToJSON(rows)
+	if err != nil {
+		t.Fatalf("Error converting row: %v", err)
+	}
+	e := `{"id":1,"name":"a \"b\"","price":12.5,"day":"2020-01-02",` +
+		`"at":"2020-01-02T10:20:30.5Z","starts":"10:20:30","data":"yv4=",` +
+		`"doc":{"k": [1, 2]},"ok":null}`
+	if string(b) != e {
+		t.Errorf("Invalid JSON:\n%s\nexpected:\n%s", b, e)
+	}
+}
@@ -0,0 +1,118 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// NamedArgs holds the arguments of a query with :name placeholders, by
+// name. Passed as the only argument of a query, each placeholder is
+// replaced by a ? placeholder bound to the argument of that name:
+//
+//	db.Exec("INSERT INTO t VALUES (:id, :name)",
+//		monetdb.NamedArgs{"id": 1, "name": "x"})
+//
+// A placeholder without an argument is an error, arguments without a
+// placeholder are ignored.
+type NamedArgs map[string]interface{}
+
+// namedArgs returns the NamedArgs of a statement, if they are its only
+// argument.
+func namedArgs(args []driver.Value) (NamedArgs, bool, error) {
+	for _, a := range args {
+		if n, ok := a.(NamedArgs); ok {
+			if len(args) != 1 {
+				return nil, false, fmt.Errorf("NamedArgs must be the only argument")
+			}
+			return n, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// parseNamed replaces the :name placeholders of a query with ? and
+// returns the names in the order of the placeholders. Colons in string
+// literals, quoted identifiers and comments are left alone.
+func parseNamed(query string) (string, []string) {
+	var b strings.Builder
+	names := []string{}
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			j := skipQuoted(query, i)
+			b.WriteString(query[i:j])
+			i = j - 1
+			continue
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			b.WriteString(query[i : i+j])
+			i += j - 1
+			continue
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i
+			} else {
+				j += 4
+			}
+			b.WriteString(query[i : i+j])
+			i += j - 1
+			continue
+		case ch == ':' && i+1 < len(query) && isNameStart(query[i+1]) && (i == 0 || query[i-1] != ':'):
+			j := i + 1
+			for j < len(query) && isNameChar(query[j]) {
+				j++
+			}
+			names = append(names, query[i+1:j])
+			b.WriteByte('?')
+			i = j - 1
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String(), names
+}
+
+// bindNamed returns the arguments for the placeholders with the given
+// names.
+func bindNamed(names []string, args NamedArgs) ([]driver.Value, error) {
+	values := make([]driver.Value, len(names))
+	for i, name := range names {
+		v, ok := args[name]
+		if !ok {
+			return nil, fmt.Errorf("Missing named argument: %s", name)
+		}
+		v, err := namedValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid named argument %s: %v", name, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// namedValue converts a named argument like database/sql converts the
+// other arguments, leaving the types the driver converts itself alone.
+func namedValue(v interface{}) (driver.Value, error) {
+	if _, ok := toMonetMapper(v); ok {
+		return v, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isNameChar(ch byte) bool {
+	return isNameStart(ch) || ch >= '0' && ch <= '9'
+}
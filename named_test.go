@@ -0,0 +1,70 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestNamedArgs(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	args := NamedArgs{"id": 1, "price": 2.5, "name": "x", "unused": true}
+	if _, err := db.Exec("INSERT INTO t VALUES (:id, :price, :name)", args); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	stmt, err := db.Prepare("INSERT INTO t VALUES (:id, :price, :name) -- ':x'")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := stmt.Exec(args); err != nil {
+			t.Fatalf("Error executing statement: %v", err)
+		}
+	}
+
+	_, err = stmt.Exec(NamedArgs{"id": 1, "price": 2.5})
+	if err == nil || !strings.Contains(err.Error(), "Missing named argument: name") {
+		t.Errorf("Expected missing argument error, got: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"sINSERT INTO t VALUES (1, 2.5, 'x');",
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?) -- ':x';",
+		"sEXECUTE 3(1, 2.5, 'x');",
+		"sEXECUTE 3(1, 2.5, 'x');")
+}
+
+func TestParseNamed(t *testing.T) {
+	type tc struct {
+		query    string
+		expected string
+		names    []string
+	}
+	var tcs = []tc{
+		tc{"SELECT 1", "SELECT 1", []string{}},
+		tc{"SELECT :a, :b_1, :a", "SELECT ?, ?, ?", []string{"a", "b_1", "a"}},
+		tc{"SELECT ':a', \":b\" /* :c */ FROM t WHERE x = :d", "SELECT ':a', \":b\" /* :c */ FROM t WHERE x = ?", []string{"d"}},
+		tc{"SELECT x::int, :1", "SELECT x::int, :1", []string{}},
+	}
+	for _, c := range tcs {
+		q, names := parseNamed(c.query)
+		if q != c.expected || strings.Join(names, ",") != strings.Join(c.names, ",") {
+			t.Errorf("Expected %q %v for %q, got %q %v", c.expected, c.names, c.query, q, names)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	mdb_INET = "inet"
+	mdb_CIDR = "cidr"
+)
+
+func init() {
+	toGoMappers[mdb_INET] = toInet
+	toGoMappers[mdb_CIDR] = toCIDR
+	toMonetMappers["netip.Addr"] = toInetString
+	toMonetMappers["netip.Prefix"] = toInetString
+}
+
+// toInet converts an inet to a netip.Addr. An address with a netmask
+// shorter than the address converts to a netip.Prefix, so the mask is
+// not lost. IPv4-mapped IPv6 addresses are kept as they are.
+func toInet(v string) (driver.Value, error) {
+	v = strings.Trim(v, "'\"")
+	if !strings.Contains(v, "/") {
+		a, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid inet value: %s", v)
+		}
+		return a, nil
+	}
+
+	p, err := netip.ParsePrefix(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid inet value: %s", v)
+	}
+	if p.Bits() == p.Addr().BitLen() {
+		return p.Addr(), nil
+	}
+	return p, nil
+}
+
+// toCIDR converts a cidr to a netip.Prefix.
+func toCIDR(v string) (driver.Value, error) {
+	v = strings.Trim(v, "'\"")
+	p, err := netip.ParsePrefix(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cidr value: %s", v)
+	}
+	return p, nil
+}
+
+func toInetString(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case netip.Addr:
+		if !val.IsValid() {
+			return "", fmt.Errorf("Invalid inet value: zero netip.Addr")
+		}
+		return toQuotedString(val.String())
+	case netip.Prefix:
+		if !val.IsValid() {
+			return "", fmt.Errorf("Invalid cidr value: zero netip.Prefix")
+		}
+		return toQuotedString(val.String())
+	}
+	return "", fmt.Errorf("Unsupported type")
+}
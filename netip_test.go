@@ -0,0 +1,55 @@
+//go:build go1.18
+// +build go1.18
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestConvertInet(t *testing.T) {
+	type tc struct {
+		v        string
+		dataType string
+		e        interface{}
+		literal  string
+	}
+	var tcs = []tc{
+		tc{"192.168.1.7", "inet", netip.MustParseAddr("192.168.1.7"), "'192.168.1.7'"},
+		tc{"192.168.1.7/32", "inet", netip.MustParseAddr("192.168.1.7"), "'192.168.1.7'"},
+		tc{"::ffff:192.0.2.1", "inet", netip.MustParseAddr("::ffff:192.0.2.1"), "'::ffff:192.0.2.1'"},
+		tc{"192.168.1.7/24", "inet", netip.MustParsePrefix("192.168.1.7/24"), "'192.168.1.7/24'"},
+		tc{"192.168.1.0/24", "cidr", netip.MustParsePrefix("192.168.1.0/24"), "'192.168.1.0/24'"},
+		tc{"2001:db8::/32", "cidr", netip.MustParsePrefix("2001:db8::/32"), "'2001:db8::/32'"},
+	}
+
+	for _, c := range tcs {
+		v, err := convertToGo(c.v, c.dataType)
+		if err != nil {
+			t.Errorf("Error converting %s: %v", c.v, err)
+			continue
+		}
+		if v != c.e {
+			t.Errorf("Invalid value: %v, expected: %v", v, c.e)
+		}
+
+		s, err := convertToMonet(v)
+		if err != nil {
+			t.Errorf("Error converting %v: %v", v, err)
+		} else if s != c.literal {
+			t.Errorf("Invalid literal: %s, expected: %s", s, c.literal)
+		}
+	}
+
+	if _, err := convertToGo("192.168.1.300", "inet"); err == nil {
+		t.Errorf("Expected error converting invalid inet")
+	}
+	if _, err := convertToMonet(netip.Addr{}); err == nil {
+		t.Errorf("Expected error converting zero netip.Addr")
+	}
+}
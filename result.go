@@ -4,10 +4,20 @@
 
 package monetdb
 
+import "errors"
+
+// ErrUseQuery is returned by LastInsertId for a statement that returned
+// values other than a single integer, such as an INSERT with a RETURNING
+// clause for several rows or columns. Run it with Query to read them.
+var ErrUseQuery = errors.New("Statement returned a result set, read it with Query")
+
 type Result struct {
 	lastInsertId int
 	rowsAffected int
 	err          error
+
+	// lastInsertIdErr is returned by LastInsertId, if set.
+	lastInsertIdErr error
 }
 
 func newResult() Result {
@@ -19,6 +29,9 @@ func newResult() Result {
 }
 
 func (r Result) LastInsertId() (int64, error) {
+	if r.err == nil && r.lastInsertIdErr != nil {
+		return 0, r.lastInsertIdErr
+	}
 	return int64(r.lastInsertId), r.err
 }
 
@@ -0,0 +1,113 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// Retry runs statements on a database, retrying them when they fail
+// because of a bad connection.
+//
+// The driver returns driver.ErrBadConn only when a statement was not sent
+// to the server, so retrying it cannot execute it twice. Errors reported
+// by the server, and connections lost while waiting for a response, are
+// not retried. Note that database/sql already retries a bad connection a
+// couple of times before giving up; Retry adds attempts on top of that.
+type Retry struct {
+	db       *sql.DB
+	attempts int
+}
+
+// WithRetry returns a Retry that runs statements on db at most attempts
+// times.
+func WithRetry(db *sql.DB, attempts int) *Retry {
+	return &Retry{
+		db:       db,
+		attempts: attempts,
+	}
+}
+
+// Do calls f until it succeeds, returns an error other than a bad
+// connection, or the attempts are used up.
+func (r *Retry) Do(f func() error) error {
+	var err error
+	for i := 0; i < r.attempts || i == 0; i++ {
+		err = f()
+		if !errors.Is(err, driver.ErrBadConn) {
+			return err
+		}
+	}
+	return err
+}
+
+// Exec executes a statement like sql.DB.Exec.
+func (r *Retry) Exec(query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := r.Do(func() (err error) {
+		res, err = r.db.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+// Query executes a query like sql.DB.Query.
+func (r *Retry) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := r.Do(func() (err error) {
+		rows, err = r.db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+type retryIdempotentKey struct{}
+
+// WithRetryIdempotent returns a context that lets the queries run with it
+// reconnect and run again once, if the connection is found dead before
+// the query is sent, e.g. because the server closed it while it was idle.
+// A query is never run again once any of its response was read, and
+// never inside a transaction, whose state is lost with the session.
+//
+// database/sql already does this for connections from the pool, but not
+// for a connection pinned with sql.Conn. It is meant for queries that can
+// safely run twice, such as a SELECT.
+func WithRetryIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryIdempotentKey{}, true)
+}
+
+// retryIdempotentFor reports whether ctx was made with WithRetryIdempotent.
+func retryIdempotentFor(ctx context.Context) bool {
+	retry, _ := ctx.Value(retryIdempotentKey{}).(bool)
+	return retry
+}
+
+// canRetry reports whether a statement that failed with err can be run
+// again on a new session.
+func (c *Conn) canRetry(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) && !c.inTx && !c.config.NoAutocommit
+}
+
+// reconnect replaces the session of c with a new one, made with the same
+// configuration. The prepared statements of the old session are dropped.
+// Connecting gives up when ctx is done.
+func (c *Conn) reconnect(ctx context.Context) error {
+	if c.mapi == nil {
+		return driver.ErrBadConn
+	}
+
+	n, err := newConn(ctx, c.config)
+	if err != nil {
+		return err
+	}
+	c.mapi.Disconnect()
+	c.mapi = n.mapi
+	c.stmtCache.clear()
+	c.serverVersion = ""
+	return nil
+}
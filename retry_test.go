@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBadConn(t *testing.T) {
+	r := WithRetry(nil, 3)
+
+	calls := 0
+	err := r.Do(func() error {
+		calls++
+		if calls == 1 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Invalid result: %v after %d calls, expected: success after 2 calls", err, calls)
+	}
+}
+
+func TestRetryStatementError(t *testing.T) {
+	r := WithRetry(nil, 3)
+
+	calls := 0
+	e := errors.New("Database error: syntax error")
+	err := r.Do(func() error {
+		calls++
+		return e
+	})
+	if err != e || calls != 1 {
+		t.Errorf("Invalid result: %v after %d calls, expected: %v after 1 call", err, calls, e)
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	r := WithRetry(nil, 3)
+
+	calls := 0
+	err := r.Do(func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	if err != driver.ErrBadConn || calls != 3 {
+		t.Errorf("Invalid result: %v after %d calls, expected: %v after 3 calls", err, calls, driver.ErrBadConn)
+	}
+}
+
+// droppingServer answers queries with a single row, but closes the first
+// connection after its first query, like a server ending an idle session.
+func droppingServer(conns *int32) func(*MapiConn) {
+	return func(m *MapiConn) {
+		n := atomic.AddInt32(conns, 1)
+		if _, err := handshake(m); err != nil {
+			return
+		}
+		for {
+			if _, err := m.getBlock(); err != nil {
+				return
+			}
+			m.putBlock([]byte("&1 1 1 1 1\n" +
+				"% .t # table_name\n" +
+				"% i # name\n" +
+				"% int # type\n" +
+				"% 1 # length\n" +
+				"[ 1\t]\n"))
+			if n == 1 {
+				return
+			}
+		}
+	}
+}
+
+func TestWithRetryIdempotent(t *testing.T) {
+	defer checkAlways()()
+	for _, retry := range []bool{false, true} {
+		var conns int32
+		srv := newFakeServer(t, droppingServer(&conns))
+		db, err := sql.Open("monetdb", srv.dsn())
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+
+		var i int
+		if err := conn.QueryRowContext(context.Background(), "SELECT 1").Scan(&i); err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		ctx := context.Background()
+		if retry {
+			ctx = WithRetryIdempotent(ctx)
+		}
+		err = conn.QueryRowContext(ctx, "SELECT 1").Scan(&i)
+		switch {
+		case retry && err != nil:
+			t.Errorf("Error querying with retry: %v", err)
+		case retry && atomic.LoadInt32(&conns) != 2:
+			t.Errorf("Invalid number of connections: %d, expected: 2", atomic.LoadInt32(&conns))
+		case !retry && !errors.Is(err, driver.ErrBadConn):
+			t.Errorf("Invalid error: %v, expected: %v", err, driver.ErrBadConn)
+		}
+
+		conn.Close()
+		db.Close()
+		srv.Close()
+	}
+}
+
+func TestWithRetryIdempotentDeadline(t *testing.T) {
+	var conns int32
+	srv := newFakeServer(t, func(m *MapiConn) {
+		if atomic.AddInt32(&conns, 1) == 1 {
+			droppingServer(new(int32))(m)
+			return
+		}
+		// never log in the new connection
+		m.getBlock()
+	})
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	var i int
+	if err := conn.QueryRowContext(context.Background(), "SELECT 1").Scan(&i); err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(WithRetryIdempotent(context.Background()), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := conn.QueryRowContext(ctx, "SELECT 1").Scan(&i); err == nil {
+		t.Errorf("Expected error querying when reconnecting hangs")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Query took %v, past its deadline", d)
+	}
+}
@@ -6,10 +6,17 @@ package monetdb
 
 import (
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 )
 
+// ErrRowLimitExceeded is returned by Rows.Next when a result set has more
+// rows than the max_rows DSN option allows.
+var ErrRowLimitExceeded = errors.New("Row limit exceeded")
+
 type Rows struct {
 	stmt   *Stmt
 	active bool
@@ -25,6 +32,12 @@ type Rows struct {
 	rows        [][]driver.Value
 	description []description
 	columns     []string
+
+	// current holds the row read by NextRow
+	current []driver.Value
+
+	// pending holds the result sets of the response after this one
+	pending []string
 }
 
 func newRows(s *Stmt) *Rows {
@@ -38,18 +51,147 @@ func newRows(s *Stmt) *Rows {
 	}
 }
 
+// Columns returns the names of the columns in the order of the result
+// set. Names need not be unique, e.g. when a join selects the same column
+// of two tables. With the qualified_names DSN option, the names are
+// prefixed with the name of their table.
 func (r *Rows) Columns() []string {
 	if r.columns == nil {
+		qualified := r.stmt != nil && r.stmt.conn != nil && r.stmt.conn.config.QualifiedNames
 		r.columns = make([]string, len(r.description))
 		for i, d := range r.description {
 			r.columns[i] = d.columnName
+			if qualified && d.tableName != "" {
+				r.columns[i] = qualifiedName(d.tableName, d.columnName)
+			}
 		}
 	}
 	return r.columns
 }
 
+// qualifiedName returns the name of a column prefixed with the name of its
+// table. The server sends the table name with its schema, which is left
+// out.
+func qualifiedName(table, column string) string {
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		table = table[i+1:]
+	}
+	if table == "" {
+		return column
+	}
+	return table + "." + column
+}
+
+// typeNames maps the type names used in result sets to the names the
+// MonetDB catalog uses for them, where they differ by more than case.
+var typeNames = map[string]string{
+	mdb_INT:            "INTEGER",
+	mdb_TIMESTAMPTZ:    "TIMESTAMP WITH TIME ZONE",
+	mdb_TIMETZ:         "TIME WITH TIME ZONE",
+	mdb_SEC_INTERVAL:   "INTERVAL SECOND",
+	mdb_MONTH_INTERVAL: "INTERVAL MONTH",
+	mdb_DAY_INTERVAL:   "INTERVAL DAY",
+	mdb_HOUR_INTERVAL:  "INTERVAL HOUR",
+}
+
+// ColumnTypeDatabaseTypeName implements
+// driver.RowsColumnTypeDatabaseTypeName. It returns the type name as the
+// MonetDB catalog has it, e.g. "VARCHAR" or "TIMESTAMP WITH TIME ZONE".
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	t := r.description[index].columnType
+	if n, ok := typeNames[t]; ok {
+		return n
+	}
+	return strings.ToUpper(t)
+}
+
+// ColumnTypeLength implements driver.RowsColumnTypeLength. It returns the
+// declared length of string and blob columns, such as 50 for a
+// VARCHAR(50) or a CLOB(1000), or math.MaxInt64 if they have none, as a
+// CLOB or a BLOB. It comes from the typesizes header, as the length header
+// holds the width of the values in the result. Other types are not of
+// variable length.
+func (r *Rows) ColumnTypeLength(index int) (int64, bool) {
+	d := r.description[index]
+	switch baseType(d.columnType) {
+	case mdb_CHAR, mdb_VARCHAR, mdb_CLOB, mdb_BLOB, mdb_JSON:
+		if d.internalSize > 0 {
+			return int64(d.internalSize), true
+		}
+		return math.MaxInt64, true
+	}
+	return 0, false
+}
+
+// load makes the rows the ones of the result last stored by the
+// statement.
+func (r *Rows) load() {
+	s := r.stmt
+	r.queryId = s.queryId
+	r.lastRowId = s.lastRowId
+	r.rowCount = s.rowCount
+	r.offset = s.offset
+	r.rows = s.rows
+	r.description = s.description
+	r.columns = nil
+	r.current = nil
+	r.rowNum = 0
+}
+
+// HasNextResultSet implements driver.RowsNextResultSet.
+func (r *Rows) HasNextResultSet() bool {
+	return len(r.pending) > 0
+}
+
+// NextResultSet implements driver.RowsNextResultSet. It moves to the next
+// result set of the response, such as the trace of a TRACE statement.
+func (r *Rows) NextResultSet() error {
+	if !r.active {
+		return fmt.Errorf("Rows closed")
+	}
+	if len(r.pending) == 0 {
+		return io.EOF
+	}
+
+	if r.offset+len(r.rows) < r.rowCount && r.stmt.conn != nil {
+		if _, err := r.stmt.conn.cmd(fmt.Sprintf("Xclose %d", r.queryId)); err != nil {
+			return err
+		}
+	}
+
+	next := r.pending[0]
+	r.pending = r.pending[1:]
+	if err := r.stmt.storeResult(next); err != nil {
+		return err
+	}
+	r.load()
+	return nil
+}
+
+// Close releases the result set. If not all of its rows were fetched,
+// the server is told to drop the rest, so the connection can be reused
+// right away. The rows fetched so far are dropped as well.
 func (r *Rows) Close() error {
+	if !r.active {
+		return nil
+	}
 	r.active = false
+
+	pending := r.offset+len(r.rows) < r.rowCount
+	r.rows = nil
+	r.current = nil
+	r.pending = nil
+	if r.stmt == nil {
+		return nil
+	}
+	// the statement shares the last block of rows
+	r.stmt.rows = nil
+
+	if pending && r.stmt.conn != nil {
+		if _, err := r.stmt.conn.cmd(fmt.Sprintf("Xclose %d", r.queryId)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -62,10 +204,17 @@ func (r *Rows) Next(dest []driver.Value) error {
 	if r.queryId == -1 {
 		return fmt.Errorf("Query didn't result in a resultset")
 	}
+	// a statement without a result, such as SET, has no rows
+	if len(r.description) == 0 {
+		return io.EOF
+	}
 
 	if r.rowNum >= r.rowCount {
 		return io.EOF
 	}
+	if max := r.maxRows(); max > 0 && r.rowNum >= max {
+		return ErrRowLimitExceeded
+	}
 
 	if r.rowNum >= r.offset+len(r.rows) {
 		err := r.fetchNext()
@@ -98,13 +247,28 @@ func min(a, b int) int {
 	}
 }
 
+// maxRows returns the row limit of the connection, or 0 if there is none.
+func (r *Rows) maxRows() int {
+	return r.stmt.conn.config.MaxRows
+}
+
 func (r *Rows) fetchNext() error {
 	if r.rowNum >= r.rowCount {
 		return io.EOF
 	}
 
 	r.offset += len(r.rows)
-	end := min(r.rowCount, r.rowNum+c_ARRAY_SIZE)
+	size := c_ARRAY_SIZE
+	switch n := r.stmt.fetchSize; {
+	case n > 0:
+		size = n
+	case n < 0:
+		size = r.rowCount
+	}
+	end := min(r.rowCount, r.rowNum+size)
+	if max := r.maxRows(); max > 0 {
+		end = min(end, max)
+	}
 	amount := end - r.offset
 
 	cmd := fmt.Sprintf("Xexport %d %d %d", r.queryId, r.offset, amount)
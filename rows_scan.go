@@ -0,0 +1,109 @@
+//go:build go1.27
+// +build go1.27
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// NextRow implements driver.RowsColumnScanner.
+func (r *Rows) NextRow() error {
+	if r.current == nil {
+		r.current = make([]driver.Value, len(r.description))
+	}
+	return r.Next(r.current)
+}
+
+// ScanColumn implements driver.RowsColumnScanner. It lets integer columns
+// used as flags be scanned into a bool, with any non-zero value being
+// true, as well as strings such as 'true' returned by some catalog
+// queries, DATE and TIME columns into a string or a time.Time, see
+// Date.Time and Time.Time, and UUID columns into a [16]byte. Everything
+// else is converted the way database/sql does.
+//
+// These conversions need Go 1.27 or later, which added
+// driver.RowsColumnScanner. With earlier versions database/sql converts
+// all columns itself.
+func (r *Rows) ScanColumn(scanCtx driver.ScanContext, index int, dest interface{}) error {
+	v := r.current[index]
+	switch d := dest.(type) {
+	case *bool:
+		if b, ok := intToBool(v); ok {
+			*d = b
+			return nil
+		}
+		if b, ok := stringToBool(v); ok {
+			*d = b
+			return nil
+		}
+	case *string:
+		switch val := v.(type) {
+		case Date:
+			*d = val.String()
+			return nil
+		case Time:
+			*d = val.String()
+			return nil
+		}
+	case *time.Time:
+		switch val := v.(type) {
+		case Date:
+			*d = val.Time()
+			return nil
+		case Time:
+			*d = val.Time()
+			return nil
+		}
+	case *[16]byte:
+		if v != nil && r.description[index].columnType == mdb_UUID {
+			return (*UUID)(d).Scan(v)
+		}
+	}
+	return sql.ConvertAssign(scanCtx, dest, v)
+}
+
+// intToBool converts the integer types returned for integer columns.
+func intToBool(v driver.Value) (bool, bool) {
+	switch val := v.(type) {
+	case int8:
+		return val != 0, true
+	case int16:
+		return val != 0, true
+	case int32:
+		return val != 0, true
+	case int64:
+		return val != 0, true
+	}
+	return false, false
+}
+
+// stringToBool converts a boolean returned as a string, which may be
+// quoted, such as 'true' or false.
+func stringToBool(v driver.Value) (bool, bool) {
+	var s string
+	switch val := v.(type) {
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	default:
+		return false, false
+	}
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = s[1 : len(s)-1]
+	}
+	b, err := toBool(s)
+	if err != nil {
+		return false, false
+	}
+	return b.(bool), true
+}
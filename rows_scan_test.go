@@ -0,0 +1,197 @@
+//go:build go1.27
+// +build go1.27
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScanUUIDBytes(t *testing.T) {
+	srv := uuidServer(t)
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT u FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var b [16]byte
+	rows.Next()
+	if err := rows.Scan(&b); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	e := [16]byte{0x65, 0xbc, 0xa9, 0xe4, 0x7e, 0x1c, 0x4c, 0x6d, 0x9f, 0x39, 0x4b, 0x9a, 0x1e, 0x6f, 0x0c, 0x2a}
+	if b != e {
+		t.Errorf("Invalid uuid: %x, expected: %x", b, e)
+	}
+
+	rows.Next()
+	if err := rows.Scan(&b); err == nil {
+		t.Errorf("Expected error scanning a malformed uuid")
+	}
+}
+
+func TestScanTinyintFlag(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 3 2 3\n" +
+			"% .t,\t.t # table_name\n" +
+			"% f,\tn # name\n" +
+			"% tinyint,\tsmallint # type\n" +
+			"% 1,\t1 # length\n" +
+			"[ 0,\t0\t]\n" +
+			"[ 1,\t2\t]\n" +
+			"[ -3,\t300\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT f, n FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	e := []bool{false, true, true}
+	i := 0
+	for rows.Next() {
+		var f int8
+		var b bool
+		if err := rows.Scan(&f, &b); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		var fb bool
+		var n int16
+		if err := rows.Scan(&fb, &n); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		if b != e[i] || fb != (f != 0) {
+			t.Errorf("Invalid flags: %v, %v (%d), expected: %v", b, fb, f, e[i])
+		}
+		i++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+	if i != 3 {
+		t.Errorf("Invalid number of rows: %d, expected: 3", i)
+	}
+}
+
+func TestScanStringBool(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 3 1 3\n" +
+			"% sys.t # table_name\n" +
+			"% system # name\n" +
+			"% varchar # type\n" +
+			"% 7 # length\n" +
+			"[ \"'true'\"\t]\n" +
+			"[ \"false\"\t]\n" +
+			"[ \"TRUE\"\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT system FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var got []bool
+	for rows.Next() {
+		var b bool
+		if err := rows.Scan(&b); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		got = append(got, b)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+	if e := []bool{true, false, true}; !reflect.DeepEqual(got, e) {
+		t.Errorf("Invalid values: %v, expected: %v", got, e)
+	}
+}
+
+func TestScanString(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 4 1\n" +
+			"% .t,\t.t,\t.t,\t.t # table_name\n" +
+			"% i,\td,\tt,\tf # name\n" +
+			"% int,\tdate,\ttime,\tdouble # type\n" +
+			"% 2,\t10,\t8,\t24 # length\n" +
+			"[ 42,\t2020-01-02,\t13:14:15,\t2.5\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	v := make([]string, 4)
+	if err := db.QueryRow("SELECT i, d, t, f FROM t").Scan(&v[0], &v[1], &v[2], &v[3]); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	e := []string{"42", "2020-01-02", "13:14:15", "2.5"}
+	for i := range e {
+		if v[i] != e[i] {
+			t.Errorf("Invalid value: %s, expected: %s", v[i], e[i])
+		}
+	}
+}
+
+func TestScanTime(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 2 1\n" +
+			"% .t,\t.t # table_name\n" +
+			"% d,\tt # name\n" +
+			"% date,\ttime # type\n" +
+			"% 10,\t8 # length\n" +
+			"[ 2020-01-02,\t13:14:15\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var d, tm time.Time
+	if err := db.QueryRow("SELECT d, t FROM t").Scan(&d, &tm); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	if e := time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC); !d.Equal(e) {
+		t.Errorf("Invalid date: %v, expected: %v", d, e)
+	}
+	if e := time.Date(1970, time.January, 1, 13, 14, 15, 0, time.UTC); !tm.Equal(e) {
+		t.Errorf("Invalid time: %v, expected: %v", tm, e)
+	}
+}
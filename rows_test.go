@@ -0,0 +1,1066 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestColumnTypeDatabaseTypeName(t *testing.T) {
+	r := newRows(nil)
+	r.description = []description{
+		description{columnName: "a", columnType: "varchar"},
+		description{columnName: "b", columnType: "decimal"},
+		description{columnName: "c", columnType: "timestamptz"},
+		description{columnName: "d", columnType: "int"},
+		description{columnName: "e", columnType: "sec_interval"},
+		description{columnName: "f", columnType: "timetz"},
+	}
+	e := []string{"VARCHAR", "DECIMAL", "TIMESTAMP WITH TIME ZONE", "INTEGER", "INTERVAL SECOND",
+		"TIME WITH TIME ZONE"}
+
+	for i, n := range e {
+		if v := r.ColumnTypeDatabaseTypeName(i); v != n {
+			t.Errorf("Invalid type name: %s, expected: %s", v, n)
+		}
+	}
+}
+
+func TestScanBlobNull(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 1 2\n" +
+			"% .t # table_name\n" +
+			"% b # name\n" +
+			"% blob # type\n" +
+			"% 0 # length\n" +
+			"[ NULL\t]\n" +
+			"[ \t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT b FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var blobs [][]byte
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		blobs = append(blobs, b)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+
+	if len(blobs) != 2 {
+		t.Fatalf("Invalid number of rows: %d, expected: 2", len(blobs))
+	}
+	if blobs[0] != nil {
+		t.Errorf("Invalid NULL blob: %#v, expected: nil", blobs[0])
+	}
+	if blobs[1] == nil || len(blobs[1]) != 0 {
+		t.Errorf("Invalid empty blob: %#v, expected: []byte{}", blobs[1])
+	}
+}
+
+func TestMaxRows(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "Xexport") {
+			return "&6 1 1 1 2\n[ 3\t]\n"
+		}
+		return "&1 1 10 1 2\n" +
+			"% .t # table_name\n" +
+			"% i # name\n" +
+			"% int # type\n" +
+			"% 2 # length\n" +
+			"[ 1\t]\n" +
+			"[ 2\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?max_rows=3")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT i FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("Invalid number of rows: %d, expected: 3", n)
+	}
+	if err := rows.Err(); err != ErrRowLimitExceeded {
+		t.Errorf("Invalid error: %v, expected: %v", err, ErrRowLimitExceeded)
+	}
+
+	expectCommands(t, cmds, "sSELECT i FROM t;", "Xexport 1 2 1")
+}
+
+func TestRowsCloseEarly(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "Xclose") {
+			return ""
+		}
+		return "&1 4 100000 1 2\n" +
+			"% .t # table_name\n" +
+			"% i # name\n" +
+			"% int # type\n" +
+			"% 6 # length\n" +
+			"[ 1\t]\n" +
+			"[ 2\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	rows, err := db.Query("SELECT i FROM big")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	for i := 0; i < 2 && rows.Next(); i++ {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("Error closing rows: %v", err)
+	}
+
+	var i int
+	if err := db.QueryRow("SELECT i FROM big").Scan(&i); err != nil || i != 1 {
+		t.Errorf("Connection not reusable after closing rows: %d (%v)", i, err)
+	}
+
+	expectCommands(t, cmds, "sSELECT i FROM big;", "Xclose 4", "sSELECT i FROM big;", "Xclose 4")
+}
+
+func TestRowsCloseReleases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping in short mode")
+	}
+
+	block := strings.Repeat("[ \"some text to fill the block with\"\t]\n", 100)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		if strings.HasPrefix(cmd, "Xclose") {
+			return ""
+		}
+		return "&1 1 1000 1 100\n" +
+			"% .t # table_name\n" +
+			"% s # name\n" +
+			"% varchar # type\n" +
+			"% 32 # length\n" + block
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	query := func() {
+		rows, err := db.Query("SELECT s FROM t")
+		if err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		rows.Next()
+		if err := rows.Close(); err != nil {
+			t.Fatalf("Error closing rows: %v", err)
+		}
+	}
+
+	query()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 10000; i++ {
+		query()
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("Goroutines leaked: %d, expected: %d", n, goroutines)
+	}
+	if growth := int64(after.HeapAlloc) - int64(before.HeapAlloc); growth > 1<<20 {
+		t.Errorf("Heap grew by %d bytes", growth)
+	}
+}
+
+func TestScanOID(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 2 2\n" +
+			"% sys.objects,\tsys.objects # table_name\n" +
+			"% id,\tname # name\n" +
+			"% oid,\tvarchar # type\n" +
+			"% 5,\t4 # length\n" +
+			"[ 2001@0,\t\"id\"\t]\n" +
+			"[ 2002@0,\t\"name\"\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, name FROM sys.objects")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []OID
+	for rows.Next() {
+		var id OID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 2 || ids[0] != 2001 || ids[1] != 2002 {
+		t.Errorf("Invalid oids: %v, expected: [2001 2002]", ids)
+	}
+}
+
+func TestScanHexBlob(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 1 2\n" +
+			"% .t # table_name\n" +
+			"% b # name\n" +
+			"% blob # type\n" +
+			"% 0 # length\n" +
+			"[ DEADBEEF00\t]\n" +
+			"[ NULL\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	for _, c := range []struct {
+		dest     interface{}
+		expected []interface{}
+	}{
+		{new(HexBlob), []interface{}{HexBlob("DEADBEEF00"), HexBlob("")}},
+		{new([]byte), []interface{}{[]byte{0xde, 0xad, 0xbe, 0xef, 0}, []byte(nil)}},
+	} {
+		rows, err := db.Query("SELECT b FROM t")
+		if err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		for i := 0; rows.Next(); i++ {
+			if err := rows.Scan(c.dest); err != nil {
+				t.Fatalf("Error scanning: %v", err)
+			}
+			v := reflect.ValueOf(c.dest).Elem().Interface()
+			if !reflect.DeepEqual(v, c.expected[i]) {
+				t.Errorf("Invalid value: %#v, expected: %#v", v, c.expected[i])
+			}
+		}
+		rows.Close()
+	}
+}
+
+// uuidServer answers every query with a uuid column of a valid and a
+// malformed uuid.
+func uuidServer(t *testing.T) *fakeServer {
+	return newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 1 2\n" +
+			"% .t # table_name\n" +
+			"% u # name\n" +
+			"% uuid # type\n" +
+			"% 36 # length\n" +
+			"[ 65bca9e4-7e1c-4c6d-9f39-4b9a1e6f0c2a\t]\n" +
+			"[ 65bca9e4-7e1c\t]\n"
+	}))
+}
+
+func TestScanUUID(t *testing.T) {
+	srv := uuidServer(t)
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	e := "65bca9e4-7e1c-4c6d-9f39-4b9a1e6f0c2a"
+	for _, dest := range []interface{}{new(string), new(UUID)} {
+		rows, err := db.Query("SELECT u FROM t")
+		if err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		rows.Next()
+		if err := rows.Scan(dest); err != nil {
+			t.Errorf("Error scanning into %T: %v", dest, err)
+		} else if s := fmt.Sprint(reflect.ValueOf(dest).Elem().Interface()); s != e {
+			t.Errorf("Invalid uuid: %s, expected: %s", s, e)
+		}
+
+		rows.Next()
+		err = rows.Scan(dest)
+		if _, ok := dest.(*UUID); ok && err == nil {
+			t.Errorf("Expected error scanning a malformed uuid")
+		}
+		rows.Close()
+	}
+}
+
+func TestAggregateTypes(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 7 1\n" +
+			"% .,\t.,\t.,\t.,\t.,\t.,\t. # table_name\n" +
+			"% s,\ta,\tc,\th,\tl,\td,\tn # name\n" +
+			"% decimal(38,2),\tdouble,\tbigint,\thugeint,\tlng,\tdbl,\tnumeric # type\n" +
+			"% 40,\t24,\t1,\t2,\t1,\t24,\t4 # length\n" +
+			"[ 12345678901234567890.12,\t2.5,\t3,\t42,\t7,\t1.5,\t3.25\t]\n"
+	}))
+	defer srv.Close()
+
+	// the sum has more digits than a float64 holds
+	db, err := sql.Open("monetdb", srv.dsn()+"?decimal=exact")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var s string
+	var a, d, n float64
+	var c, h, l int64
+	err = db.QueryRow("SELECT SUM(x), AVG(y), COUNT(*), SUM(z), COUNT(z), AVG(z), SUM(w) FROM t").
+		Scan(&s, &a, &c, &h, &l, &d, &n)
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	if s != "12345678901234567890.12" || a != 2.5 || c != 3 || h != 42 || l != 7 || d != 1.5 || n != 3.25 {
+		t.Errorf("Invalid values: %s, %v, %d, %d, %d, %v, %v", s, a, c, h, l, d, n)
+	}
+}
+
+func TestMissingTypeLine(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 2 2\n" +
+			"% .p,\t.p # table_name\n" +
+			"% name,\tvalue # name\n" +
+			"[ \"threads\",\t8\t]\n" +
+			"[ \"mode\",\tNULL\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("CALL sys.settings()")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var got [][2]interface{}
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		got = append(got, [2]interface{}{name, value})
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+
+	e := [][2]interface{}{
+		{"threads", sql.NullString{String: "8", Valid: true}},
+		{"mode", sql.NullString{}},
+	}
+	if !reflect.DeepEqual(got, e) {
+		t.Errorf("Invalid rows: %v, expected: %v", got, e)
+	}
+}
+
+func TestDuplicateColumnNames(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 2 1\n" +
+			"% sys.a,\tsys.b # table_name\n" +
+			"% id,\tid # name\n" +
+			"% int,\tint # type\n" +
+			"% 1,\t1 # length\n" +
+			"[ 1,\t2\t]\n"
+	}))
+	defer srv.Close()
+
+	for _, c := range []struct {
+		dsn     string
+		columns []string
+	}{
+		{srv.dsn(), []string{"id", "id"}},
+		{srv.dsn() + "?qualified_names=true", []string{"a.id", "b.id"}},
+	} {
+		db, err := sql.Open("monetdb", c.dsn)
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+
+		rows, err := db.Query("SELECT a.id, b.id FROM a, b")
+		if err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			t.Fatalf("Error reading columns: %v", err)
+		}
+		if strings.Join(columns, ",") != strings.Join(c.columns, ",") {
+			t.Errorf("Invalid columns: %v, expected: %v", columns, c.columns)
+		}
+
+		var a, b int
+		if !rows.Next() {
+			t.Fatalf("Missing row: %v", rows.Err())
+		}
+		if err := rows.Scan(&a, &b); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		if a != 1 || b != 2 {
+			t.Errorf("Invalid values: %d, %d, expected: 1, 2", a, b)
+		}
+		rows.Close()
+		db.Close()
+	}
+}
+
+func TestEmptyResult(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 0 2 0\n" +
+			"% sys.t,\tsys.t # table_name\n" +
+			"% a,\tb # name\n" +
+			"% int,\tvarchar # type\n" +
+			"% 1,\t0 # length\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT a, b FROM t WHERE 1=0")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatalf("Error reading column types: %v", err)
+	}
+	if len(types) != 2 || types[0].Name() != "a" || types[1].DatabaseTypeName() != "VARCHAR" {
+		t.Errorf("Invalid column types: %v", types)
+	}
+
+	if rows.Next() {
+		t.Errorf("Unexpected row")
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("Error reading rows: %v", err)
+	}
+}
+
+func TestColumnTypeLength(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 6 1\n" +
+			"% sys.t,\tsys.t,\tsys.t,\tsys.t,\tsys.t,\tsys.t # table_name\n" +
+			"% v,\tc,\tbc,\tb,\td,\ti # name\n" +
+			"% varchar,\tclob,\tclob,\tblob,\tdecimal,\tint # type\n" +
+			"% 5,\t5,\t3,\t4,\t7,\t1 # length\n" +
+			"% 50 0,\t0 0,\t1000 0,\t0 0,\t10 2,\t32 0 # typesizes\n" +
+			"[ \"hello\",\t\"world\",\t\"abc\",\tCAFE,\t1.50,\t1\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT v, c, bc, b, d, i FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatalf("Error reading column types: %v", err)
+	}
+	e := []struct {
+		length int64
+		ok     bool
+	}{
+		{50, true},
+		{math.MaxInt64, true},
+		{1000, true},
+		{math.MaxInt64, true},
+		{0, false},
+		{0, false},
+	}
+	for i, c := range types {
+		if l, ok := c.Length(); l != e[i].length || ok != e[i].ok {
+			t.Errorf("Invalid length of %s: %d (%v), expected: %d (%v)", c.Name(), l, ok, e[i].length, e[i].ok)
+		}
+	}
+}
+
+func TestRawValues(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 3 2\n" +
+			"% sys.t,\tsys.t,\tsys.t # table_name\n" +
+			"% d,\tdt,\ts # name\n" +
+			"% decimal,\tdate,\tvarchar # type\n" +
+			"% 5,\t10,\t6 # length\n" +
+			"[ 1.50,\t2020-01-02,\t\"a\\tb\"\t]\n" +
+			"[ NULL,\tNULL,\t\"NULL\"\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?raw_values=true")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT d, dt, s FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var result [][]sql.NullString
+	for rows.Next() {
+		v := make([]sql.NullString, 3)
+		if err := rows.Scan(&v[0], &v[1], &v[2]); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		result = append(result, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+
+	e := [][]sql.NullString{
+		{{String: "1.50", Valid: true}, {String: "2020-01-02", Valid: true}, {String: "a\tb", Valid: true}},
+		{{}, {}, {String: "NULL", Valid: true}},
+	}
+	if len(result) != len(e) {
+		t.Fatalf("Invalid number of rows: %d, expected: %d", len(result), len(e))
+	}
+	for i := range e {
+		for j := range e[i] {
+			if result[i][j] != e[i][j] {
+				t.Errorf("Invalid value in row %d, column %d: %v, expected: %v", i, j, result[i][j], e[i][j])
+			}
+		}
+	}
+}
+
+func TestScanQuotedNull(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 1 2\n" +
+			"% sys.t # table_name\n" +
+			"% s # name\n" +
+			"% varchar # type\n" +
+			"% 4 # length\n" +
+			"[ \"NULL\"\t]\n" +
+			"[ NULL\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT s FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var result []sql.NullString
+	for rows.Next() {
+		var s sql.NullString
+		if err := rows.Scan(&s); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		result = append(result, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+
+	e := []sql.NullString{{String: "NULL", Valid: true}, {}}
+	if len(result) != 2 || result[0] != e[0] || result[1] != e[1] {
+		t.Errorf("Invalid values: %v, expected: %v", result, e)
+	}
+}
+
+// rangeServer returns a handler that answers every query with a result
+// of the integers 1 to n, sending them in blocks of 100 rows.
+func rangeServer(n int) func(*MapiConn) {
+	block := func(offset, amount int) string {
+		var b strings.Builder
+		for i := offset; i < offset+amount && i < n; i++ {
+			fmt.Fprintf(&b, "[ %d\t]\n", i+1)
+		}
+		return b.String()
+	}
+	return serveCommands(func(cmd string) string {
+		if strings.HasPrefix(cmd, "Xclose") {
+			return ""
+		}
+		var id, offset, amount int
+		if _, err := fmt.Sscanf(cmd, "Xexport %d %d %d", &id, &offset, &amount); err == nil {
+			return fmt.Sprintf("&6 1 1 %d %d\n", amount, offset) + block(offset, amount)
+		}
+		return fmt.Sprintf("&1 1 %d 1 %d\n", n, min(n, 100)) +
+			"% .t # table_name\n" +
+			"% i # name\n" +
+			"% bigint # type\n" +
+			"% 8 # length\n" +
+			block(0, 100)
+	})
+}
+
+func TestForEachRow(t *testing.T) {
+	srv := newFakeServer(t, rangeServer(10000))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	var sum int64
+	err = conn.ForEachRow(context.Background(), "SELECT i FROM t", nil, func(row []driver.Value) error {
+		sum += row[0].(int64)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+	if sum != 10000*10001/2 {
+		t.Errorf("Invalid sum: %d, expected: %d", sum, 10000*10001/2)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = conn.ForEachRow(context.Background(), "SELECT i FROM t", nil, func(row []driver.Value) error {
+		n++
+		if n == 150 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 150 {
+		t.Errorf("Invalid error: %v after %d rows, expected: %v after 150 rows", err, n, stop)
+	}
+
+	// the connection is still usable
+	if err := conn.ForEachRow(context.Background(), "SELECT i FROM t", nil, func(row []driver.Value) error {
+		return nil
+	}); err != nil {
+		t.Errorf("Error reading rows after stopping early: %v", err)
+	}
+}
+
+func BenchmarkForEachRow(b *testing.B) {
+	srv := newFakeServer(b, rangeServer(10000))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		b.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+	conn := c.(*Conn)
+
+	for i := 0; i < b.N; i++ {
+		var sum int64
+		err := conn.ForEachRow(context.Background(), "SELECT i FROM t", nil, func(row []driver.Value) error {
+			sum += row[0].(int64)
+			return nil
+		})
+		if err != nil {
+			b.Fatalf("Error reading rows: %v", err)
+		}
+	}
+}
+
+func BenchmarkScanRows(b *testing.B) {
+	srv := newFakeServer(b, rangeServer(10000))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		b.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Query("SELECT i FROM t")
+		if err != nil {
+			b.Fatalf("Error querying: %v", err)
+		}
+		var sum int64
+		for rows.Next() {
+			var v int64
+			if err := rows.Scan(&v); err != nil {
+				b.Fatalf("Error scanning: %v", err)
+			}
+			sum += v
+		}
+		if err := rows.Err(); err != nil {
+			b.Fatalf("Error reading rows: %v", err)
+		}
+		rows.Close()
+	}
+}
+
+func TestUnknownType(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 2 1\n" +
+			"% sys.t,\tsys.t # table_name\n" +
+			"% i,\tg # name\n" +
+			"% int,\texotic # type\n" +
+			"% 1,\t10 # length\n" +
+			"[ 1,\tPOINT (1 2)\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var i int
+	var g string
+	err = db.QueryRow("SELECT i, g FROM t").Scan(&i, &g)
+	if err == nil || !strings.Contains(err.Error(), "Type not supported") {
+		t.Errorf("Invalid error: %v, expected: Type not supported", err)
+	}
+
+	db2, err := sql.Open("monetdb", srv.dsn()+"?unknown_type=raw")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db2.Close()
+
+	if err := db2.QueryRow("SELECT i, g FROM t").Scan(&i, &g); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	if i != 1 || g != "POINT (1 2)" {
+		t.Errorf("Invalid values: %d, %s, expected: 1, POINT (1 2)", i, g)
+	}
+
+	if _, err := parseDSN("localhost/testdb?unknown_type=lenient"); err == nil {
+		t.Errorf("Error parsing DSN with invalid unknown_type")
+	}
+}
+
+func TestTraceResultSets(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 1 1\n" +
+			"% .%1 # table_name\n" +
+			"% %1 # name\n" +
+			"% tinyint # type\n" +
+			"% 1 # length\n" +
+			"[ 1\t]\n" +
+			"&1 1 2 2 2\n" +
+			"% .trace,\t.trace # table_name\n" +
+			"% usec,\tstatement # name\n" +
+			"% bigint,\tclob # type\n" +
+			"% 2,\t28 # length\n" +
+			"[ 12,\t\"X_1:bat[:bte] := bat.single(1:bte);\"\t]\n" +
+			"[ 3,\t\"sql.resultSet(X_1);\"\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("TRACE SELECT 1")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var values []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		values = append(values, v)
+	}
+	if len(values) != 1 || values[0] != 1 {
+		t.Errorf("Invalid result: %v, expected: [1]", values)
+	}
+
+	if !rows.NextResultSet() {
+		t.Fatalf("Missing trace result set: %v", rows.Err())
+	}
+	columns, _ := rows.Columns()
+	if strings.Join(columns, ",") != "usec,statement" {
+		t.Errorf("Invalid trace columns: %v, expected: [usec statement]", columns)
+	}
+	var usec []int64
+	for rows.Next() {
+		var u int64
+		var s string
+		if err := rows.Scan(&u, &s); err != nil {
+			t.Fatalf("Error scanning trace: %v", err)
+		}
+		usec = append(usec, u)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading trace: %v", err)
+	}
+	if len(usec) != 2 || usec[0] != 12 || usec[1] != 3 {
+		t.Errorf("Invalid trace timings: %v, expected: [12 3]", usec)
+	}
+
+	if rows.NextResultSet() {
+		t.Errorf("Unexpected third result set")
+	}
+}
+
+func TestHeaderOrder(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 3 1\n" +
+			"# a comment\n" +
+			"% 10 2,\t32 0,\t20 0 # typesizes\n" +
+			"% decimal,\tint,\tvarchar # type\n" +
+			"% 1,\t1,\t0 # extra_metadata\n" +
+			"% a,\tb,\tc # name\n" +
+			"% 5,\t1,\t5 # length\n" +
+			"% sys.t,\tsys.t,\tsys.t # table_name\n" +
+			"[ 1.50,\t2,\t\"three\"\t]\n"
+	}))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	rows, err := newStmt(c.(*Conn), "SELECT a, b, c FROM t").Query(nil)
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+	r := rows.(*Rows)
+
+	e := []description{
+		{tableName: "sys.t", columnName: "a", columnType: "decimal", displaySize: 5, internalSize: 10, precision: 10, scale: 2},
+		{tableName: "sys.t", columnName: "b", columnType: "int", displaySize: 1, internalSize: 32},
+		{tableName: "sys.t", columnName: "c", columnType: "varchar", displaySize: 5, internalSize: 20},
+	}
+	if len(r.description) != len(e) {
+		t.Fatalf("Invalid number of columns: %d, expected: %d", len(r.description), len(e))
+	}
+	for i := range e {
+		if r.description[i] != e[i] {
+			t.Errorf("Invalid column: %+v, expected: %+v", r.description[i], e[i])
+		}
+	}
+
+	dest := make([]driver.Value, 3)
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("Error reading row: %v", err)
+	}
+	if string(dest[2].([]byte)) != "three" {
+		t.Errorf("Invalid value: %v, expected: three", dest[2])
+	}
+}
+
+func TestQueryWithoutResult(t *testing.T) {
+	for _, response := range []string{"", "&3\n", "&2 5 -1\n"} {
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			return response
+		}))
+
+		db, err := sql.Open("monetdb", srv.dsn())
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+
+		rows, err := db.Query("SET SCHEMA sys")
+		if err != nil {
+			t.Fatalf("Error querying with response %q: %v", response, err)
+		}
+		cols, err := rows.Columns()
+		if err != nil || cols == nil || len(cols) != 0 {
+			t.Errorf("Invalid columns: %v (%v), expected none", cols, err)
+		}
+		if rows.Next() {
+			t.Errorf("Expected no rows with response %q", response)
+		}
+		if err := rows.Err(); err != nil {
+			t.Errorf("Error reading rows with response %q: %v", response, err)
+		}
+		rows.Close()
+
+		db.Close()
+		srv.Close()
+	}
+}
+
+func TestHighPrecisionDecimal(t *testing.T) {
+	const exact = "1234567890123456789012345678.1234567890"
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 2 1\n" +
+			"% sys.t,\tsys.t # table_name\n" +
+			"% big,\tsmall # name\n" +
+			"% decimal,\tdecimal # type\n" +
+			"% 40,\t5 # length\n" +
+			"% 38 10,\t10 2 # typesizes\n" +
+			"[ " + exact + ",\t12.50\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// a float64 by default, whatever the precision of the type
+	var big, small interface{}
+	if err := db.QueryRow("SELECT big, small FROM t").Scan(&big, &small); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	if _, ok := big.(float64); !ok || small != 12.5 {
+		t.Errorf("Invalid decimals: %v (%T), %v (%T), expected float64s", big, big, small, small)
+	}
+
+	db, err = sql.Open("monetdb", srv.dsn()+"?decimal=exact")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var s string
+	if err := db.QueryRow("SELECT big, small FROM t").Scan(&s, &small); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	if s != exact {
+		t.Errorf("Invalid decimal: %s, expected: %s", s, exact)
+	}
+
+	var d Decimal
+	if err := db.QueryRow("SELECT big, small FROM t").Scan(&d, &small); err != nil {
+		t.Fatalf("Error scanning: %v", err)
+	}
+	if d.String() != exact {
+		t.Errorf("Invalid decimal: %s, expected: %s", d, exact)
+	}
+}
+
+func TestExactDecimals(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 1 2 1\n" +
+			"% sys.t,\tsys.t # table_name\n" +
+			"% d,\tn # name\n" +
+			"% decimal,\tdecimal # type\n" +
+			"% 5,\t4 # length\n" +
+			"% 10 2,\t10 2 # typesizes\n" +
+			"[ 12.50,\tNULL\t]\n"
+	}))
+	defer srv.Close()
+
+	type tc struct {
+		dsn string
+		e   interface{}
+	}
+	var tcs = []tc{
+		tc{srv.dsn(), 12.5},
+		tc{srv.dsn() + "?decimal=float", 12.5},
+		tc{srv.dsn() + "?decimal=exact", "12.50"},
+	}
+	for _, c := range tcs {
+		db, err := sql.Open("monetdb", c.dsn)
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+
+		var d, n interface{}
+		if err := db.QueryRow("SELECT d, n FROM t").Scan(&d, &n); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		// strings are scanned into an interface{} as bytes
+		if b, ok := d.([]byte); ok {
+			d = string(b)
+		}
+		if d != c.e || n != nil {
+			t.Errorf("Invalid decimals with %s: %v (%T), %v, expected: %v (%T), nil", c.dsn, d, d, n, c.e, c.e)
+		}
+		db.Close()
+	}
+}
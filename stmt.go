@@ -5,17 +5,54 @@
 package monetdb
 
 import (
+	"bytes"
+	"context"
 	"database/sql/driver"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// OnQuery, if set, is called after every statement is executed, with the
+// SQL text, the time it took, the number of rows it affected or returned,
+// and the error, if any. Arguments are not passed, but the SQL text may
+// still hold sensitive data. It is called on the goroutine executing the
+// statement, so it should return quickly. Set it before opening
+// connections.
+var OnQuery func(query string, duration time.Duration, rows int64, err error)
+
+// OnSlowQuery, if set, is called instead of the standard logger for every
+// statement that took longer than the slow_query_threshold of its
+// connection, with the SQL text and the time it took. Like OnQuery, it is
+// called on the goroutine executing the statement.
+var OnSlowQuery func(query string, duration time.Duration)
+
 type Stmt struct {
 	conn  *Conn
 	query string
 
+	// comment is prepended to the commands sent for the statement,
+	// see WithComment.
+	comment string
+
+	// fetchSize is the number of rows fetched at a time, -1 for all,
+	// or 0 for the default, see WithFetchSize.
+	fetchSize int
+
+	// retryIdempotent runs a query again on a new session if the
+	// connection was found dead before it was sent, see
+	// WithRetryIdempotent.
+	retryIdempotent bool
+
 	execId int
+	// prepared is the prepared statement execId refers to.
+	prepared *preparedStmt
+
+	// names are the names of the :name placeholders of the query,
+	// which are replaced with ? once it is run with NamedArgs
+	names []string
 
 	lastRowId   int
 	rowCount    int
@@ -25,9 +62,13 @@ type Stmt struct {
 
 	rows        [][]driver.Value
 	description []description
+
+	paramTypes      []string
+	paramConverters []toMonetConverter
 }
 
 type description struct {
+	tableName    string
 	columnName   string
 	columnType   string
 	displaySize  int
@@ -47,20 +88,66 @@ func newStmt(c *Conn, q string) *Stmt {
 }
 
 func (s *Stmt) Close() error {
+	var err error
+	if s.conn != nil {
+		err = s.release()
+	}
 	s.conn = nil
-	return nil
+	return err
 }
 
+// release stops using the prepared statement, which is deallocated on the
+// server if no other Stmt uses it and the statement cache does not hold
+// it. The statement is prepared again when it is run next.
+func (s *Stmt) release() error {
+	p := s.prepared
+	s.prepared = nil
+	s.execId = -1
+	if p == nil {
+		return nil
+	}
+	p.users--
+	return s.conn.deallocate(p)
+}
+
+// NumInput returns the number of placeholders in the query. If the query
+// has string literals, quoted identifiers or comments, which may hold a
+// question mark that is not a placeholder, it returns -1, and checking
+// the number of arguments is left to the server. So it does for a query
+// with :name placeholders, which takes a single NamedArgs argument.
 func (s *Stmt) NumInput() int {
-	return -1
+	if s.names != nil || strings.ContainsAny(s.query, "'\":") || strings.Contains(s.query, "--") || strings.Contains(s.query, "/*") {
+		return -1
+	}
+	return strings.Count(s.query, "?")
 }
 
-func (s *Stmt) Exec(args []driver.Value) (driver.Result, error) {
-	res := newResult()
+// CheckNamedValue implements driver.NamedValueChecker. Arguments of a
+// type the driver converts itself are passed through unchanged, any other
+// type is left to the default conversion of database/sql.
+func (s *Stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(NamedArgs); ok {
+		return nil
+	}
+	if _, ok := toMonetMapper(nv.Value); ok {
+		return nil
+	}
+	return driver.ErrSkip
+}
 
+func (s *Stmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
 	r, err := s.exec(args)
+	return s.result(start, r, err)
+}
+
+// result builds the result of a statement that started at start from the
+// response r of the server, or the error executing it.
+func (s *Stmt) result(start time.Time, r string, err error) (driver.Result, error) {
+	res := newResult()
 	if err != nil {
 		res.err = err
+		s.reportQuery(start, 0, err)
 		return res, res.err
 	}
 
@@ -68,77 +155,354 @@ func (s *Stmt) Exec(args []driver.Value) (driver.Result, error) {
 	res.lastInsertId = s.lastRowId
 	res.rowsAffected = s.rowCount
 	res.err = err
+	if err == nil && s.description != nil {
+		err = s.returnedResult(&res)
+	}
 
+	s.reportQuery(start, res.rowsAffected, err)
 	return res, res.err
 }
 
 func (s *Stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.queryContext(context.Background(), args)
+}
+
+// QueryContext implements driver.StmtQueryContext. A query run again on
+// a new session, see WithRetryIdempotent, gives up connecting when ctx is
+// done.
+func (s *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryContext(ctx, values)
+}
+
+func (s *Stmt) queryContext(ctx context.Context, args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
 	rows := newRows(s)
 
-	r, err := s.exec(args)
+	r, err := s.execFetch(args)
+	if err != nil && s.retryIdempotent && s.conn.canRetry(err) {
+		if s.conn.reconnect(ctx) == nil {
+			s.release()
+			r, err = s.execFetch(args)
+		}
+	}
 	if err != nil {
 		rows.err = err
+		s.reportQuery(start, 0, err)
 		return rows, rows.err
 	}
 
+	// a response may hold several result sets, e.g. the result and
+	// the trace of a TRACE statement, which are read one by one
+	if results := splitResults(r); len(results) > 0 {
+		r = results[0]
+		rows.pending = results[1:]
+	}
+
 	err = s.storeResult(r)
 	if err != nil {
 		rows.err = err
 	}
-	rows.queryId = s.queryId
-	rows.lastRowId = s.lastRowId
-	rows.rowCount = s.rowCount
-	rows.offset = s.offset
-	rows.rows = s.rows
-	rows.description = s.description
+	rows.load()
 
+	s.reportQuery(start, rows.rowCount, rows.err)
 	return rows, rows.err
 }
 
+// splitResults splits a response in its result sets, if it has any. Each
+// one starts with the header of a table. Replies to other statements
+// before the first result set are dropped.
+func splitResults(r string) []string {
+	var results []string
+	start := -1
+	for i := 0; i < len(r); {
+		end := strings.IndexByte(r[i:], '\n')
+		if end < 0 {
+			end = len(r)
+		} else {
+			end += i + 1
+		}
+		if strings.HasPrefix(r[i:], mapi_MSG_QTABLE+" ") {
+			if start >= 0 {
+				results = append(results, r[start:i])
+			}
+			start = i
+		}
+		i = end
+	}
+	if start >= 0 {
+		results = append(results, r[start:])
+	}
+	return results
+}
+
+// returnedResult fills in res for a statement that returned a result set,
+// such as an INSERT with a RETURNING clause. The rows it returned are
+// the rows it affected, and a single integer it returned, such as the
+// generated key of a row, is its last insert id. For other values
+// LastInsertId fails, as they can only be read with Query.
+func (s *Stmt) returnedResult(res *Result) error {
+	res.lastInsertId = 0
+	res.lastInsertIdErr = ErrUseQuery
+	if s.rowCount == 1 && len(s.rows) == 1 && len(s.rows[0]) == 1 {
+		switch v := s.rows[0][0].(type) {
+		case int64:
+			res.lastInsertId, res.lastInsertIdErr = int(v), nil
+		case int32:
+			res.lastInsertId, res.lastInsertIdErr = int(v), nil
+		case int16:
+			res.lastInsertId, res.lastInsertIdErr = int(v), nil
+		case int8:
+			res.lastInsertId, res.lastInsertIdErr = int(v), nil
+		}
+	}
+
+	// the rows that did not fit in the response are not read
+	pending := len(s.rows) < s.rowCount
+	s.rows = nil
+	s.description = nil
+	if pending {
+		if _, err := s.conn.cmd(fmt.Sprintf("Xclose %d", s.queryId)); err != nil {
+			res.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// reportQuery calls OnQuery for a statement that started at start, and
+// logs it if it was slow.
+func (s *Stmt) reportQuery(start time.Time, rows int, err error) {
+	d := time.Since(start)
+	if OnQuery != nil {
+		OnQuery(s.query, d, int64(rows), err)
+	}
+	if s.conn == nil {
+		return
+	}
+	if threshold := s.conn.config.SlowQueryThreshold; threshold > 0 && d > threshold {
+		if OnSlowQuery != nil {
+			OnSlowQuery(s.query, d)
+		} else {
+			log.Printf("monetdb: slow query (%v): %s", d, s.query)
+		}
+	}
+}
+
+// execFetch runs the statement like exec, with the reply size of the session
+// set to the fetch size of the statement while it runs, if it has one.
+func (s *Stmt) execFetch(args []driver.Value) (string, error) {
+	if s.fetchSize == 0 {
+		return s.exec(args)
+	}
+
+	if _, err := s.conn.cmd(fmt.Sprintf("Xreply_size %d", s.fetchSize)); err != nil {
+		return "", err
+	}
+	r, err := s.exec(args)
+	if _, rerr := s.conn.cmd(fmt.Sprintf("Xreply_size %d", c_ARRAY_SIZE)); err == nil {
+		err = rerr
+	}
+	return r, err
+}
+
 func (s *Stmt) exec(args []driver.Value) (string, error) {
-	return s.conn.execute(s.query)
+	named, ok, err := namedArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		if s.names == nil {
+			s.query, s.names = parseNamed(s.query)
+		}
+		if args, err = bindNamed(s.names, named); err != nil {
+			return "", err
+		}
+	}
+
+	if len(args) == 0 {
+		return s.conn.execute(s.comment + s.query)
+	}
+
+	if err := s.prepare(); err != nil {
+		return "", err
+	}
+	cmd, err := s.executeCommand(args)
+	if err != nil {
+		return "", err
+	}
+	r, err := s.conn.execute(s.comment + cmd)
+	if err == nil || !isMissingPrepared(err) {
+		return r, hugeintError(args, err)
+	}
+
+	// the server dropped the prepared statement, as it does when a
+	// table it uses is altered, so it is prepared once more
+	s.conn.stmtCache.remove(s.query)
+	if s.prepared != nil {
+		s.prepared.mapi = nil
+	}
+	s.release()
+	if err := s.prepare(); err != nil {
+		return "", err
+	}
+	if cmd, err = s.executeCommand(args); err != nil {
+		return "", err
+	}
+	r, err = s.conn.execute(s.comment + cmd)
+	return r, hugeintError(args, err)
+}
+
+// isMissingPrepared reports whether err is the error of the server for
+// an EXECUTE of a prepared statement it does not have, as in
+// "07003!EXECUTE: PREPARED Statement missing 3".
+func isMissingPrepared(err error) bool {
+	return strings.Contains(err.Error(), "07003!")
+}
+
+// prepare prepares the statement on the server, unless it is prepared
+// already or found in the statement cache.
+func (s *Stmt) prepare() error {
+	if s.execId == -1 {
+		if p, ok := s.conn.stmtCache.get(s.query); ok {
+			s.execId = p.execId
+			s.paramTypes = p.paramTypes
+			s.paramConverters = p.paramConverters
+			s.prepared = p
+			p.users++
+		} else {
+			err := s.prepareQuery()
+			if err != nil {
+				return err
+			}
+			s.prepared = &preparedStmt{
+				query:           s.query,
+				execId:          s.execId,
+				paramTypes:      s.paramTypes,
+				paramConverters: s.paramConverters,
+				mapi:            s.conn.mapi,
+				users:           1,
+			}
+			for _, p := range s.conn.stmtCache.put(s.prepared) {
+				if err := s.conn.deallocate(p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// executeCommand returns the EXECUTE statement that runs the prepared
+// statement with args.
+func (s *Stmt) executeCommand(args []driver.Value) (string, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "EXECUTE %d(", s.execId)
+	for i, v := range args {
+		str, err := s.convertArg(i, v)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(str)
+	}
+	b.WriteString(")")
+
+	return b.String(), nil
 }
 
 func (s *Stmt) prepareQuery() error {
-	q := fmt.Sprintf("PREPARE %s", s.query)
+	q := fmt.Sprintf("%sPREPARE %s", s.comment, s.query)
 	r, err := s.conn.execute(q)
 	if err != nil {
 		return err
 	}
 
-	return s.storeResult(r)
+	err = s.storeResult(r)
+	if err != nil {
+		return err
+	}
+
+	// The rows describing the parameters are the ones without
+	// a schema, table and column.
+	s.paramTypes = nil
+	s.paramConverters = nil
+	for _, row := range s.rows {
+		if len(row) < 6 || row[5] != nil {
+			continue
+		}
+		t, _ := row[0].(string)
+		s.paramTypes = append(s.paramTypes, t)
+		s.paramConverters = append(s.paramConverters, toMonetParamMappers[t])
+	}
+	s.rows = nil
+	s.description = nil
+
+	return nil
+}
+
+// convertArg converts the i-th argument using the converter resolved
+// for its parameter type, if there is one. That converter rejects an
+// argument that does not fit the type before it is sent to the server.
+func (s *Stmt) convertArg(i int, v driver.Value) (string, error) {
+	if i < len(s.paramConverters) && s.paramConverters[i] != nil {
+		str, err := s.paramConverters[i](v)
+		if err != nil {
+			return "", fmt.Errorf("Invalid argument %d for parameter of type %s: %v",
+				i+1, s.paramTypes[i], err)
+		}
+		return str, nil
+	}
+	return convertToMonet(v)
 }
 
 func (s *Stmt) storeResult(r string) error {
+	var tableNames []string
 	var columnNames []string
 	var columnTypes []string
 	var displaySizes []int
 	var internalSizes []int
 	var precisions []int
 	var scales []int
+	var typeScales []int
 	var nullOks []int
+	updated := false
 
-	for _, line := range strings.Split(r, "\n") {
+	lines := strings.Split(r, "\n")
+	for _, line := range lines {
 		if strings.HasPrefix(line, mapi_MSG_INFO) {
 			// TODO log
 
-		} else if strings.HasPrefix(line, mapi_MSG_QPREPARE) {
-			t := strings.Split(strings.TrimSpace(line[2:]), " ")
-			s.execId, _ = strconv.Atoi(t[0])
-			return nil
-
-		} else if strings.HasPrefix(line, mapi_MSG_QTABLE) {
-			t := strings.Split(strings.TrimSpace(line[2:]), " ")
-			s.queryId, _ = strconv.Atoi(t[0])
-			s.rowCount, _ = strconv.Atoi(t[1])
-			s.columnCount, _ = strconv.Atoi(t[2])
+		} else if strings.HasPrefix(line, mapi_MSG_QTABLE) || strings.HasPrefix(line, mapi_MSG_QPREPARE) {
+			// the id, the number of rows and of columns
+			t, err := headerFields(line, 3)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, mapi_MSG_QPREPARE) {
+				// a prepared statement comes with a table describing
+				// its result columns and parameters
+				s.execId = t[0]
+			} else {
+				s.queryId = t[0]
+			}
+			s.rowCount = t[1]
+			s.columnCount = t[2]
+			s.rows = make([][]driver.Value, 0)
 
+			tableNames = make([]string, s.columnCount)
 			columnNames = make([]string, s.columnCount)
 			columnTypes = make([]string, s.columnCount)
 			displaySizes = make([]int, s.columnCount)
 			internalSizes = make([]int, s.columnCount)
 			precisions = make([]int, s.columnCount)
 			scales = make([]int, s.columnCount)
+			typeScales = make([]int, s.columnCount)
 			nullOks = make([]int, s.columnCount)
 
 		} else if strings.HasPrefix(line, mapi_MSG_TUPLE) {
@@ -148,6 +512,10 @@ func (s *Stmt) storeResult(r string) error {
 			}
 			s.rows = append(s.rows, v)
 
+		} else if strings.HasPrefix(line, mapi_MSG_TUPLE_NOSLICE) {
+			// the line is the value, as is
+			s.rows = append(s.rows, []driver.Value{line[1:]})
+
 		} else if strings.HasPrefix(line, mapi_MSG_QBLOCK) {
 			s.rows = make([][]driver.Value, 0)
 
@@ -159,9 +527,21 @@ func (s *Stmt) storeResult(r string) error {
 			s.rowCount = 0
 
 		} else if strings.HasPrefix(line, mapi_MSG_QUPDATE) {
-			t := strings.Split(strings.TrimSpace(line[2:]), " ")
-			s.rowCount, _ = strconv.Atoi(t[0])
-			s.lastRowId, _ = strconv.Atoi(t[1])
+			// a statement such as MERGE may report the rows it
+			// inserted and updated separately, which add up
+			t, err := headerFields(line, 1)
+			if err != nil {
+				return err
+			}
+			if updated {
+				s.rowCount += t[0]
+			} else {
+				s.rowCount = t[0]
+			}
+			updated = true
+			if len(t) > 1 {
+				s.lastRowId = t[1]
+			}
 
 		} else if strings.HasPrefix(line, mapi_MSG_QTRANS) {
 			s.offset = 0
@@ -171,41 +551,62 @@ func (s *Stmt) storeResult(r string) error {
 			s.rowCount = 0
 
 		} else if strings.HasPrefix(line, mapi_MSG_HEADER) {
-			t := strings.Split(line[1:], "#")
-			data := strings.TrimSpace(t[0])
-			identity := strings.TrimSpace(t[1])
-
-			values := make([]string, 0)
-			for _, value := range strings.Split(data, ",") {
-				values = append(values, strings.TrimSpace(value))
+			// the header lines may come in any order, and lines
+			// of an unknown kind are skipped
+			i := strings.LastIndex(line, "#")
+			if i < 0 || columnNames == nil {
+				continue
 			}
+			data := strings.TrimSpace(line[1:i])
+			identity := strings.TrimSpace(line[i+1:])
 
-			if identity == "name" {
-				columnNames = values
-
-			} else if identity == "type" {
-				columnTypes = values
+			values := strings.Split(data, ",\t")
+			for i, value := range values {
+				values[i] = strings.TrimSpace(value)
+			}
 
-			} else if identity == "typesizes" {
-				sizes := make([][]int, len(values))
-				for i, value := range values {
-					s := make([]int, 0)
-					for _, v := range strings.Split(value, " ") {
-						val, _ := strconv.Atoi(v)
-						s = append(s, val)
+			switch identity {
+			case "table_name":
+				copy(tableNames, values)
+			case "name":
+				copy(columnNames, values)
+			case "type":
+				copy(columnTypes, values)
+			case "length":
+				for i := 0; i < len(values) && i < len(displaySizes); i++ {
+					displaySizes[i], _ = strconv.Atoi(values[i])
+				}
+			case "typesizes":
+				for i := 0; i < len(values) && i < len(internalSizes); i++ {
+					sizes := strings.Fields(values[i])
+					if len(sizes) > 0 {
+						internalSizes[i], _ = strconv.Atoi(sizes[0])
+					}
+					if len(sizes) > 1 {
+						typeScales[i], _ = strconv.Atoi(sizes[1])
 					}
-					internalSizes[i] = s[0]
-					sizes = append(sizes, s)
 				}
-				for j, t := range columnTypes {
-					if t == "decimal" {
-						precisions[j] = sizes[j][0]
-						scales[j] = sizes[j][1]
+			default:
+				continue
+			}
+
+			// the digits and scale of typesizes are the precision
+			// and scale of decimals, unless the type has them, as in
+			// decimal(38,2)
+			for i, t := range columnTypes {
+				if baseType(t) == mdb_DECIMAL {
+					precisions[i] = internalSizes[i]
+					scales[i] = typeScales[i]
+					if p, sc, ok := typeParams(t); ok {
+						precisions[i], scales[i] = p, sc
 					}
+				} else {
+					precisions[i] = 0
+					scales[i] = 0
 				}
 			}
 
-			s.updateDescription(columnNames, columnTypes, displaySizes,
+			s.updateDescription(tableNames, columnNames, columnTypes, displaySizes,
 				internalSizes, precisions, scales, nullOks)
 			s.offset = 0
 			s.lastRowId = 0
@@ -217,9 +618,39 @@ func (s *Stmt) storeResult(r string) error {
 		}
 	}
 
+	// DDL and transaction statements may be answered with just "&3" or
+	// "&4 t", without the prompt after it, and statements such as SET
+	// with nothing at all
+	if r == "" {
+		return nil
+	}
+	last := lines[len(lines)-1]
+	if strings.HasPrefix(last, mapi_MSG_QSCHEMA) || strings.HasPrefix(last, mapi_MSG_QTRANS) {
+		return nil
+	}
 	return fmt.Errorf("Unknown state: %s", r)
 }
 
+// headerFields returns the numbers following the kind of a result header,
+// such as 5 and -1 for "&2 5 -1". It fails if the first n are not all
+// there, so a truncated header is not taken for an empty result. Fields
+// after those that are not numbers are dropped.
+func headerFields(line string, n int) ([]int, error) {
+	fields := strings.Fields(line[2:])
+	t := make([]int, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			break
+		}
+		t = append(t, v)
+	}
+	if len(t) < n {
+		return nil, fmt.Errorf("Invalid result header: %s", line)
+	}
+	return t, nil
+}
+
 func (s *Stmt) parseTuple(d string) ([]driver.Value, error) {
 	items := strings.Split(d[1:len(d)-1], ",\t")
 	if len(items) != len(s.description) {
@@ -238,12 +669,13 @@ func (s *Stmt) parseTuple(d string) ([]driver.Value, error) {
 }
 
 func (s *Stmt) updateDescription(
-	columnNames, columnTypes []string, displaySizes,
+	tableNames, columnNames, columnTypes []string, displaySizes,
 	internalSizes, precisions, scales, nullOks []int) {
 
 	d := make([]description, len(columnNames))
 	for i, _ := range columnNames {
 		desc := description{
+			tableName:    tableNames[i],
 			columnName:   columnNames[i],
 			columnType:   columnTypes[i],
 			displaySize:  displaySizes[i],
@@ -259,6 +691,16 @@ func (s *Stmt) updateDescription(
 }
 
 func (s *Stmt) convert(value, dataType string) (driver.Value, error) {
-	val, err := convertToGo(value, dataType)
+	if s.conn.config.RawValues || dataType == "" {
+		// a result without a type line, as some procedures send,
+		// is returned as text
+		return toRawValue(value)
+	}
+	if s.conn.config.UnknownTypeRaw {
+		if _, ok := lookupToGoMapper(s.conn.toGoMappers, dataType); !ok {
+			return toRawValue(value)
+		}
+	}
+	val, err := convertToGoWith(s.conn.toGoMappers, value, dataType)
 	return val, err
 }
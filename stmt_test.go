@@ -0,0 +1,962 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// prepareResponse is the reply to PREPARE INSERT INTO t VALUES (?, ?, ?)
+// for a table t with an int, a double and a varchar column.
+const prepareResponse = "&5 3 3 6 3\n" +
+	"% .prepare,\t.prepare,\t.prepare,\t.prepare,\t.prepare,\t.prepare # table_name\n" +
+	"% type,\tdigits,\tscale,\tschema,\ttable,\tcolumn # name\n" +
+	"% varchar,\tint,\tint,\tvarchar,\tvarchar,\tvarchar # type\n" +
+	"[ \"int\",\t32,\t0,\tNULL,\tNULL,\tNULL\t]\n" +
+	"[ \"double\",\t53,\t0,\tNULL,\tNULL,\tNULL\t]\n" +
+	"[ \"varchar\",\t10,\t0,\tNULL,\tNULL,\tNULL\t]\n"
+
+func prepareServer(cmds chan<- string) func(*MapiConn) {
+	return serveCommands(func(cmd string) string {
+		cmds <- cmd
+		// PREPARE may follow a comment, see WithComment
+		if strings.HasPrefix(cmd, "sPREPARE ") || strings.Contains(cmd, "*/ PREPARE ") {
+			return prepareResponse
+		}
+		return "&2 1 -1\n"
+	})
+}
+
+func TestExecPrepared(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, v := range []int{1, 2} {
+		if _, err := stmt.Exec(v, 2.5, "it's"); err != nil {
+			t.Fatalf("Error executing statement: %v", err)
+		}
+	}
+
+	expectCommands(t, cmds,
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 3(1, 2.5, 'it\\'s');",
+		"sEXECUTE 3(2, 2.5, 'it\\'s');")
+}
+
+func TestExecDecimal(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "sPREPARE ") {
+			return prepareResponse
+		}
+		if strings.HasPrefix(cmd, "sSELECT") {
+			return "&1 0 1 1 1\n" +
+				"% .t # table_name\n" +
+				"% d # name\n" +
+				"% varchar # type\n" +
+				"% 28 # length\n" +
+				"[ \"-12345678901234567.890123456\"\t]\n"
+		}
+		return "&2 1 -1\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	d, err := ParseDecimal("-12345678901234567.890123456")
+	if err != nil {
+		t.Fatalf("Error parsing decimal: %v", err)
+	}
+	stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(1, d, "x"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	var r Decimal
+	if err := db.QueryRow("SELECT CAST(d AS VARCHAR(28)) FROM t").Scan(&r); err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	if r.String() != d.String() || r.Scale != 9 {
+		t.Errorf("Invalid decimal: %v, expected: %v", r, d)
+	}
+
+	expectCommands(t, cmds,
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 3(1, -12345678901234567.890123456, 'x');",
+		"sSELECT CAST(d AS VARCHAR(28)) FROM t;")
+}
+
+func TestExecRaw(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(1, 2.5, Raw("current_timestamp")); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 3(1, 2.5, current_timestamp);")
+}
+
+func TestParamConverters(t *testing.T) {
+	type tc struct {
+		t string
+		v interface{}
+	}
+	var tcs = []tc{
+		tc{"int", int64(-42)},
+		tc{"int", 42},
+		tc{"bigint", int64(9223372036854775807)},
+		tc{"double", float64(6.4)},
+		tc{"double", float64(1e21)},
+		tc{"double", int64(7)},
+		tc{"real", float32(3.2)},
+		tc{"varchar", "quoted 'string'"},
+		tc{"varchar", []byte("bytes")},
+		tc{"int", nil},
+	}
+
+	for _, c := range tcs {
+		e, err := convertToMonet(c.v)
+		if err != nil {
+			t.Fatalf("Error converting value: %v -> %v", c.v, err)
+		}
+		s, err := toMonetParamMappers[c.t](c.v)
+		if err != nil {
+			t.Errorf("Error converting value: %v (%s) -> %v", c.v, c.t, err)
+		} else if s != e {
+			t.Errorf("Invalid value: %s (%s), expected: %s", s, c.t, e)
+		}
+	}
+}
+
+func TestBoolParams(t *testing.T) {
+	type tc struct {
+		t string
+		v interface{}
+		e string
+	}
+	var tcs = []tc{
+		tc{"boolean", true, "true"},
+		tc{"boolean", false, "false"},
+		tc{"boolean", int64(1), "true"},
+		tc{"boolean", int64(0), "false"},
+		tc{"boolean", int64(-2), "true"},
+		tc{"boolean", "t", "true"},
+		tc{"boolean", "FALSE", "false"},
+		tc{"boolean", nil, "NULL"},
+		tc{"tinyint", true, "1"},
+		tc{"tinyint", false, "0"},
+		tc{"int", true, "1"},
+		tc{"int", nil, "NULL"},
+		tc{"tinyint", BoolAsInt(true), "1"},
+		tc{"boolean", BoolAsInt(false), "0"},
+	}
+
+	for _, c := range tcs {
+		s, err := toMonetParamMappers[c.t](c.v)
+		if err != nil {
+			t.Errorf("Error converting value: %v (%s) -> %v", c.v, c.t, err)
+		} else if s != c.e {
+			t.Errorf("Invalid value: %v (%s) -> %s, expected: %s", c.v, c.t, s, c.e)
+		}
+	}
+
+	if _, err := toMonetParamMappers["boolean"]("maybe"); err == nil {
+		t.Errorf("Expected error converting invalid boolean")
+	}
+}
+
+func TestNumericParams(t *testing.T) {
+	type tc struct {
+		t string
+		v interface{}
+		e string
+	}
+	var tcs = []tc{
+		tc{"int", "42", "42"},
+		tc{"bigint", " -7 ", "-7"},
+		tc{"double", "6.4", "6.4"},
+		tc{"double", "1e21", "1e21"},
+		tc{"double", "NaN", "'NaN'"},
+		tc{"decimal", "12.50", "12.50"},
+		tc{"decimal", float64(1e21), "1000000000000000000000"},
+		tc{"decimal", float64(0.1), "0.1"},
+		tc{"decimal", int64(3), "3"},
+		tc{"decimal", Numeric("-1.5"), "-1.5"},
+		tc{"decimal", nil, "NULL"},
+	}
+
+	for _, c := range tcs {
+		s, err := toMonetParamMappers[c.t](c.v)
+		if err != nil {
+			t.Errorf("Error converting value: %v (%s) -> %v", c.v, c.t, err)
+		} else if s != c.e {
+			t.Errorf("Invalid value: %v (%s) -> %s, expected: %s", c.v, c.t, s, c.e)
+		}
+	}
+
+	for _, v := range []interface{}{"12,50", "1; DROP TABLE t", math.Inf(1)} {
+		if s, err := toMonetParamMappers["decimal"](v); err == nil {
+			t.Errorf("Expected error converting %v to a decimal, got %s", v, s)
+		}
+	}
+}
+
+// decimalPrepareResponse is the reply to PREPARE SELECT id FROM t WHERE
+// amount > ? for a decimal(10,2) column amount.
+const decimalPrepareResponse = "&5 4 2 6 2\n" +
+	"% .prepare,\t.prepare,\t.prepare,\t.prepare,\t.prepare,\t.prepare # table_name\n" +
+	"% type,\tdigits,\tscale,\tschema,\ttable,\tcolumn # name\n" +
+	"% varchar,\tint,\tint,\tvarchar,\tvarchar,\tvarchar # type\n" +
+	"[ \"int\",\t32,\t0,\t\"sys\",\t\"t\",\t\"id\"\t]\n" +
+	"[ \"decimal\",\t10,\t2,\tNULL,\tNULL,\tNULL\t]\n"
+
+func TestDecimalParam(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		switch {
+		case strings.HasPrefix(cmd, "sPREPARE "):
+			return decimalPrepareResponse
+		case cmd == "sEXECUTE 4(12.50);":
+			return "&1 0 1 1 1\n" +
+				"% sys.t # table_name\n" +
+				"% id # name\n" +
+				"% int # type\n" +
+				"% 1 # length\n" +
+				"[ 2\t]\n"
+		}
+		return "!42000!types varchar(5,0) and decimal(10,2) are not equal\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("SELECT id FROM t WHERE amount > ?")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+
+	var id int
+	if err := stmt.QueryRow("12.50").Scan(&id); err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	if id != 2 {
+		t.Errorf("Invalid id: %d, expected: 2", id)
+	}
+
+	expectCommands(t, cmds, "sPREPARE SELECT id FROM t WHERE amount > ?;", "sEXECUTE 4(12.50);")
+}
+
+func TestParamTypeMismatch(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, prepareServer(cmds))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec("forty-two", 2.5, "x")
+	e := `Invalid argument 1 for parameter of type int: Not an integer: "forty-two"`
+	if err == nil || err.Error() != e {
+		t.Errorf("Invalid error: %v, expected: %s", err, e)
+	}
+
+	expectCommands(t, cmds, "sPREPARE INSERT INTO t VALUES (?, ?, ?);")
+	select {
+	case cmd := <-cmds:
+		t.Errorf("Statement with invalid argument sent: %s", cmd)
+	default:
+	}
+}
+
+const benchmarkInserts = 100000
+
+func BenchmarkConvertToMonet(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkInserts; j++ {
+			convertToMonet(int64(j))
+			convertToMonet(float64(j))
+		}
+	}
+}
+
+func BenchmarkParamConverters(b *testing.B) {
+	toInt := toMonetParamMappers[mdb_INT]
+	toDouble := toMonetParamMappers[mdb_DOUBLE]
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkInserts; j++ {
+			toInt(int64(j))
+			toDouble(float64(j))
+		}
+	}
+}
+
+func TestQueryArrayColumn(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 1 2\n" +
+			"% .f # table_name\n" +
+			"% v # name\n" +
+			"% json # type\n" +
+			"% 9 # length\n" +
+			"[ '[1, 2, 3]'\t]\n" +
+			"[ '[]'\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT v FROM f()")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+	if len(values) != 2 || values[0] != "[1, 2, 3]" || values[1] != "[]" {
+		t.Errorf("Invalid values: %v, expected: [[1, 2, 3] []]", values)
+	}
+}
+
+type cents int64
+
+// saveMappers returns a function that restores the registered converters
+// as they are now, for tests that register their own.
+func saveMappers() func() {
+	mappersMu.Lock()
+	defer mappersMu.Unlock()
+	goMappers := make(map[string]toGoConverter, len(toGoMappers))
+	for k, v := range toGoMappers {
+		goMappers[k] = v
+	}
+	monetMappers := make(map[string]toMonetConverter, len(toMonetMappers))
+	for k, v := range toMonetMappers {
+		monetMappers[k] = v
+	}
+
+	return func() {
+		mappersMu.Lock()
+		defer mappersMu.Unlock()
+		toGoMappers = goMappers
+		toMonetMappers = monetMappers
+	}
+}
+
+func TestRegisterConverters(t *testing.T) {
+	defer saveMappers()()
+	RegisterToMonetConverter("monetdb.cents", func(v driver.Value) (string, error) {
+		c := v.(cents)
+		return fmt.Sprintf("%d.%02d", c/100, c%100), nil
+	})
+	RegisterToGoConverter("money", func(v string) (driver.Value, error) {
+		d, err := ParseDecimal(v)
+		if err != nil {
+			return nil, err
+		}
+		return d.String(), nil
+	})
+
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if strings.HasPrefix(cmd, "sPREPARE ") {
+			return prepareResponse
+		}
+		if strings.HasPrefix(cmd, "sSELECT") {
+			return "&1 0 1 1 1\n" +
+				"% .t # table_name\n" +
+				"% m # name\n" +
+				"% money # type\n" +
+				"% 8 # length\n" +
+				"[ 12.50\t]\n"
+		}
+		return "&2 1 -1\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?, ?)")
+	if err != nil {
+		t.Fatalf("Error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(1, cents(1250), "x"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	var m string
+	if err := db.QueryRow("SELECT m FROM t").Scan(&m); err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	if m != "12.50" {
+		t.Errorf("Invalid value: %s, expected: %s", m, "12.50")
+	}
+
+	expectCommands(t, cmds,
+		"sPREPARE INSERT INTO t VALUES (?, ?, ?);",
+		"sEXECUTE 3(1, 12.50, 'x');",
+		"sSELECT m FROM t;")
+}
+
+func TestRegisterConvertersConcurrent(t *testing.T) {
+	defer saveMappers()()
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		if strings.HasPrefix(cmd, "sPREPARE ") {
+			return prepareResponse
+		}
+		return "&1 0 1 1 1\n" +
+			"% .t # table_name\n" +
+			"% i # name\n" +
+			"% int # type\n" +
+			"% 1 # length\n" +
+			"[ 1\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			RegisterToGoConverter(fmt.Sprintf("ext%d", i), func(v string) (driver.Value, error) {
+				return v, nil
+			})
+			RegisterToMonetConverter(fmt.Sprintf("monetdb.ext%d", i), func(v driver.Value) (string, error) {
+				return "NULL", nil
+			})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				var v int
+				if err := db.QueryRow("SELECT i FROM t WHERE i = ?", 1).Scan(&v); err != nil {
+					t.Errorf("Error querying: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+}
+
+func TestQueryExplain(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 3 1 3\n" +
+			"% .explain # table_name\n" +
+			"% mal # name\n" +
+			"% clob # type\n" +
+			"% 60 # length\n" +
+			"=function user.main():void;\n" +
+			"=    X_1:int := sql.mvc();  # 1, \"x\"\n" +
+			"=end user.main;\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("EXPLAIN SELECT 1")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+
+	e := []string{"function user.main():void;", "    X_1:int := sql.mvc();  # 1, \"x\"", "end user.main;"}
+	if strings.Join(lines, "\n") != strings.Join(e, "\n") {
+		t.Errorf("Invalid plan: %q, expected: %q", lines, e)
+	}
+}
+
+func TestOnQuery(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		if strings.HasPrefix(cmd, "sSELECT") {
+			return "&1 0 2 1 2\n" +
+				"% .t # table_name\n" +
+				"% i # name\n" +
+				"% int # type\n" +
+				"% 1 # length\n" +
+				"[ 1\t]\n" +
+				"[ 2\t]\n"
+		}
+		if strings.HasPrefix(cmd, "sDELETE") {
+			return "&2 3 -1\n"
+		}
+		return "!42S02!SELECT: no such table 'nope'\n"
+	}))
+	defer srv.Close()
+
+	type call struct {
+		query    string
+		duration time.Duration
+		rows     int64
+		err      error
+	}
+	var calls []call
+	OnQuery = func(query string, duration time.Duration, rows int64, err error) {
+		calls = append(calls, call{query, duration, rows, err})
+	}
+	defer func() { OnQuery = nil }()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT i FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	rows.Close()
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+	db.Exec("DROP TABLE nope")
+
+	if len(calls) != 3 {
+		t.Fatalf("Invalid number of calls: %d, expected: 3", len(calls))
+	}
+	e := []call{
+		call{"SELECT i FROM t", 0, 2, nil},
+		call{"DELETE FROM t", 0, 3, nil},
+		call{"DROP TABLE nope", 0, 0, nil},
+	}
+	for i, c := range calls {
+		if c.query != e[i].query || c.rows != e[i].rows || c.duration <= 0 {
+			t.Errorf("Invalid call: %+v, expected: %+v", c, e[i])
+		}
+	}
+	if calls[0].err != nil || calls[1].err != nil || calls[2].err == nil {
+		t.Errorf("Invalid errors: %v, %v, %v", calls[0].err, calls[1].err, calls[2].err)
+	}
+}
+
+func TestOnSlowQuery(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		if strings.Contains(cmd, "slow") {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return "&2 1 -1\n"
+	}))
+	defer srv.Close()
+
+	var logged []string
+	OnSlowQuery = func(query string, duration time.Duration) {
+		if duration < 50*time.Millisecond {
+			t.Errorf("Invalid duration of %q: %v", query, duration)
+		}
+		logged = append(logged, query)
+	}
+	defer func() { OnSlowQuery = nil }()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?slow_query_threshold=50ms")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	for _, q := range []string{"DELETE FROM fast", "DELETE FROM slow"} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("Error deleting: %v", err)
+		}
+	}
+
+	if len(logged) != 1 || logged[0] != "DELETE FROM slow" {
+		t.Errorf("Invalid slow queries: %q, expected: %q", logged, []string{"DELETE FROM slow"})
+	}
+}
+
+func TestExecDDL(t *testing.T) {
+	for _, response := range []string{"&3\n", "&3", "&4 t"} {
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			return response
+		}))
+
+		db, err := sql.Open("monetdb", srv.dsn())
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+
+		res, err := db.Exec("CREATE TABLE t (i INT)")
+		if err != nil {
+			t.Fatalf("Error creating table: %v", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n != 0 {
+			t.Errorf("Invalid rows affected: %d (%v), expected: 0", n, err)
+		}
+
+		db.Close()
+		srv.Close()
+	}
+}
+
+func TestDurationRoundTrip(t *testing.T) {
+	var mu sync.Mutex
+	stored := ""
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(cmd, "sINSERT") {
+			// the seconds are quoted in the interval literal
+			stored = strings.Split(cmd, "'")[1] + ".000"
+			return "&2 1 -1\n"
+		}
+		return "&1 0 1 1 1\n" +
+			"% sys.t # table_name\n" +
+			"% d # name\n" +
+			"% sec_interval # type\n" +
+			"% 8 # length\n" +
+			"[ " + stored + "\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	for _, d := range []time.Duration{90 * time.Minute, -90 * time.Minute} {
+		if _, err := db.Exec("INSERT INTO t VALUES (?)", d); err != nil {
+			t.Fatalf("Error inserting: %v", err)
+		}
+		var r time.Duration
+		if err := db.QueryRow("SELECT d FROM t").Scan(&r); err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+		if r != d {
+			t.Errorf("Invalid duration: %v, expected: %v", r, d)
+		}
+	}
+}
+
+func TestExecMerge(t *testing.T) {
+	for _, response := range []string{"&2 5 -1\n", "&2 2 -1\n&2 3 -1\n"} {
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			return response
+		}))
+
+		db, err := sql.Open("monetdb", srv.dsn())
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+
+		res, err := db.Exec("MERGE INTO t USING s ON t.id = s.id " +
+			"WHEN MATCHED THEN UPDATE SET v = s.v " +
+			"WHEN NOT MATCHED THEN INSERT VALUES (s.id, s.v)")
+		if err != nil {
+			t.Fatalf("Error merging: %v", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 5 {
+			t.Errorf("Invalid rows affected: %d (%v), expected: 5", n, err)
+		}
+
+		db.Close()
+		srv.Close()
+	}
+}
+
+func TestNumInput(t *testing.T) {
+	tcs := map[string]int{
+		"SELECT 1":                            0,
+		"INSERT INTO t VALUES (?, ?, ?)":      3,
+		"SELECT * FROM t WHERE a = ? AND b=?": 2,
+		"SELECT '?', ?":                       -1,
+		"SELECT \"a?\" FROM t WHERE b = ?":    -1,
+		"SELECT ? -- why?":                    -1,
+		"SELECT /* ? */ ?":                    -1,
+	}
+
+	for q, n := range tcs {
+		if v := newStmt(nil, q).NumInput(); v != n {
+			t.Errorf("Invalid number of inputs for %s: %d, expected: %d", q, v, n)
+		}
+	}
+}
+
+func TestInsertReturning(t *testing.T) {
+	header := "% sys.t # table_name\n% id # name\n% int # type\n% 1 # length\n"
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		if strings.Contains(cmd, "'many'") {
+			return "&1 0 2 1 2\n" + header + "[ 42\t]\n[ 43\t]\n"
+		}
+		return "&1 0 1 1 1\n" + header + "[ 42\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	var id int
+	if err := db.QueryRow("INSERT INTO t (v) VALUES ('x') RETURNING id").Scan(&id); err != nil || id != 42 {
+		t.Errorf("Invalid returned id: %d (%v), expected: 42", id, err)
+	}
+
+	res, err := db.Exec("INSERT INTO t (v) VALUES (?) RETURNING id", "x")
+	if err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if id, err := res.LastInsertId(); err != nil || id != 42 {
+		t.Errorf("Invalid last insert id: %d (%v), expected: 42", id, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		t.Errorf("Invalid rows affected: %d (%v), expected: 1", n, err)
+	}
+
+	res, err = db.Exec("INSERT INTO t (v) VALUES (?), (?) RETURNING id", "many", "many")
+	if err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if _, err := res.LastInsertId(); err != ErrUseQuery {
+		t.Errorf("Invalid error: %v, expected: %v", err, ErrUseQuery)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 2 {
+		t.Errorf("Invalid rows affected: %d (%v), expected: 2", n, err)
+	}
+}
+
+func TestExecTimestampPrecision(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	ts := time.Date(2024, time.March, 4, 5, 6, 7, 891234567, time.UTC)
+	// CREATE TABLE t (s TIMESTAMP(0), u TIMESTAMP(6))
+	_, err = db.Exec("INSERT INTO t VALUES (?, ?)", Timestamp{ts, 0}, Timestamp{ts, 6})
+	if err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?)", Timestamp{ts, 7}, ts); err == nil {
+		t.Errorf("Expected error for invalid precision")
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?)", ts, ts); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	expectCommands(t, cmds,
+		"sINSERT INTO t VALUES ('2024-03-04 05:06:07', '2024-03-04 05:06:07.891234');",
+		"sINSERT INTO t VALUES ('2024-03-04 05:06:07.891234', '2024-03-04 05:06:07.891234');")
+}
+
+func TestExecNilBlob(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?)", []byte(nil), []byte{}); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	expectCommands(t, cmds, "sINSERT INTO t VALUES (NULL, blob '');")
+}
+
+func TestExecBoolAsInt(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, recordCommands(cmds, "&2 1 -1\n"))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// CREATE TABLE t (b BOOLEAN, f TINYINT)
+	if _, err := db.Exec("INSERT INTO t VALUES (?, ?)", true, BoolAsInt(true)); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	expectCommands(t, cmds, "sINSERT INTO t VALUES (true, 1);")
+}
+
+func TestResultHeaders(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		switch {
+		case strings.HasPrefix(cmd, "sSELECT"):
+			return "&1 7 2 1 2 12 345\n" +
+				"% .t # table_name\n" +
+				"% i # name\n" +
+				"% int # type\n" +
+				"% 1 # length\n" +
+				"[ 1\t]\n" +
+				"[ 2\t]\n"
+		case strings.HasPrefix(cmd, "sUPDATE"):
+			return "&2 3 -1 12 345\n"
+		}
+		return "&1 7\n"
+	}))
+	defer srv.Close()
+
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer c.Close()
+
+	s, _ := c.Prepare("SELECT i FROM t")
+	rows, err := s.Query(nil)
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	r, ok := rows.(*Rows)
+	if !ok || r.queryId != 7 || r.rowCount != 2 || len(r.Columns()) != 1 {
+		t.Errorf("Invalid rows: %T %+v, expected query 7 with 2 rows of 1 column", rows, rows)
+	}
+	rows.Close()
+
+	s, _ = c.Prepare("UPDATE t SET i = 0")
+	res, err := s.Exec(nil)
+	if err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Errorf("Invalid rows affected: %d (%v), expected: 3", n, err)
+	}
+	rows, err = s.Query(nil)
+	if err != nil {
+		t.Fatalf("Error querying update: %v", err)
+	}
+	if cols := rows.Columns(); len(cols) != 0 {
+		t.Errorf("Invalid columns of update: %v", cols)
+	}
+	rows.Close()
+
+	s, _ = c.Prepare("DELETE FROM t")
+	if _, err := s.Exec(nil); err == nil || !strings.Contains(err.Error(), "Invalid result header") {
+		t.Errorf("Invalid error for a truncated header: %v", err)
+	}
+}
+
+func TestRegisterConvertersRestored(t *testing.T) {
+	restore := saveMappers()
+	RegisterToGoConverter("money", toDecimal)
+	RegisterToMonetConverter("monetdb.cents", toString)
+	restore()
+
+	if _, ok := toGoMapper(nil, "money"); ok {
+		t.Errorf("Converter for money left registered")
+	}
+	if _, ok := toMonetMapper(cents(1)); ok {
+		t.Errorf("Converter for monetdb.cents left registered")
+	}
+	if _, ok := toGoMapper(nil, mdb_INT); !ok {
+		t.Errorf("Converter for int not restored")
+	}
+}
@@ -0,0 +1,320 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrNullValue is returned by QueryReader for a NULL value.
+var ErrNullValue = errors.New("Value is NULL")
+
+// QueryReader runs a query that returns a single value, such as a CLOB or
+// BLOB selected with SELECT doc FROM docs WHERE id = 1, and returns a
+// reader of it. The value is decoded as it arrives from the server, so it
+// is never held in memory as a whole: a string is unescaped and a blob
+// decoded from hex. It is reached through sql.Conn.Raw. A query without
+// rows returns sql.ErrNoRows, and a NULL value ErrNullValue.
+//
+// The connection cannot be used for anything else until the reader is
+// closed. Closing it reads the rest of the response, which the server
+// sends anyway. If ctx is done or the response cannot be read, the
+// connection is closed instead, and database/sql discards it.
+func (c *Conn) QueryReader(ctx context.Context, query string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.mapi == nil {
+		return nil, driver.ErrBadConn
+	}
+
+	stop := c.mapi.watchContext(ctx)
+	if err := c.mapi.putBlock([]byte(fmt.Sprintf("s%s;", query))); err != nil {
+		stop()
+		c.mapi.Disconnect()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, driver.ErrBadConn
+	}
+
+	r := &valueReader{
+		conn: c,
+		ctx:  ctx,
+		stop: stop,
+		msg:  bufio.NewReader(&messageReader{c: c.mapi}),
+	}
+	if err := r.start(); err != nil {
+		if cerr := r.Close(); cerr != nil {
+			err = cerr
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// valueReader decodes the first value of a query result as it reads the
+// response holding it, see QueryReader.
+type valueReader struct {
+	conn *Conn
+	ctx  context.Context
+	stop func()
+	msg  *bufio.Reader
+
+	// queryId is the id of the result, which the server keeps if it
+	// has more rows than it sent, see more.
+	queryId int
+	more    bool
+
+	// blob is whether the value is hex, quoted whether it is a quoted
+	// string, and done whether its end was read.
+	blob   bool
+	quoted bool
+	done   bool
+
+	// pending holds decoded bytes that did not fit into the buffer
+	// passed to Read.
+	pending []byte
+	runeTmp [utf8.UTFMax]byte
+
+	// err is the error reading the response, after which the
+	// connection is closed.
+	err    error
+	closed bool
+}
+
+// start reads the response up to the first value. It returns
+// sql.ErrNoRows if there is none.
+func (r *valueReader) start() error {
+	for {
+		b, err := r.msg.Peek(1)
+		if err == io.EOF {
+			r.done = true
+			return sql.ErrNoRows
+		}
+		if err != nil {
+			return r.fail(err)
+		}
+		if b[0] == mapi_MSG_TUPLE[0] {
+			break
+		}
+
+		// the lines before the rows are short
+		line, err := r.msg.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return r.fail(err)
+		}
+		switch {
+		case strings.HasPrefix(line, mapi_MSG_ERROR):
+			return fmt.Errorf("Database error: %s", strings.TrimSpace(line[1:]))
+		case strings.HasPrefix(line, mapi_MSG_QTABLE):
+			// the id, the number of rows, of columns and of rows sent
+			f := strings.Fields(line[len(mapi_MSG_QTABLE):])
+			if len(f) < 4 {
+				return fmt.Errorf("Invalid result header: %s", strings.TrimSpace(line))
+			}
+			r.queryId, _ = strconv.Atoi(f[0])
+			rows, _ := strconv.Atoi(f[1])
+			sent, _ := strconv.Atoi(f[3])
+			r.more = rows > sent
+		case strings.HasPrefix(line, mapi_MSG_HEADER) && strings.HasSuffix(strings.TrimSpace(line), "# type"):
+			t := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line[1:]), "# type"))
+			r.blob = t == mdb_BLOB
+		}
+	}
+
+	// a row is sent as [ value,\tvalue\t]
+	if _, err := r.msg.Discard(2); err != nil {
+		return r.fail(err)
+	}
+	if b, err := r.msg.Peek(len(mdb_NULL) + 1); err == nil && string(b) == mdb_NULL+"\t" {
+		r.done = true
+		return ErrNullValue
+	}
+	if b, err := r.msg.Peek(1); err == nil && b[0] == '"' {
+		r.msg.Discard(1)
+		r.quoted = true
+		r.blob = false
+	}
+	return nil
+}
+
+// fail closes the connection after reading the response failed, as the
+// rest of the response cannot be skipped.
+func (r *valueReader) fail(err error) error {
+	r.conn.mapi.Disconnect()
+	if r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	} else if err == io.EOF || errors.Is(err, ErrProtocol) {
+		err = fmt.Errorf("Connection lost: %w", err)
+	}
+	r.err = err
+	return err
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	for n < len(p) && len(r.pending) == 0 && !r.done {
+		if r.err != nil {
+			return n, r.err
+		}
+		switch {
+		case r.quoted:
+			n += r.readQuoted(p[n:])
+		case r.blob:
+			n += r.readHex(p[n:])
+		default:
+			n += r.readPlain(p[n:])
+		}
+	}
+	if n == 0 && r.err != nil {
+		return 0, r.err
+	}
+	if n == 0 && r.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// readQuoted decodes a quoted string into p, up to the first escape or
+// the end of the string.
+func (r *valueReader) readQuoted(p []byte) int {
+	n := 0
+	for n < len(p) {
+		b, err := r.msg.ReadByte()
+		if err != nil {
+			r.fail(err)
+			return n
+		}
+		switch b {
+		case '"':
+			r.done = true
+			return n
+		case '\\':
+			return n + r.readEscape(p[n:])
+		}
+		p[n] = b
+		n++
+	}
+	return n
+}
+
+// readEscape decodes the escape sequence following a backslash into p,
+// or into pending if it does not fit.
+func (r *valueReader) readEscape(p []byte) int {
+	// the longest escape is \Uhhhhhhhh
+	b, err := r.msg.Peek(9)
+	if len(b) == 0 {
+		r.fail(err)
+		return 0
+	}
+	s := "\\" + string(b)
+	var c rune
+	multibyte := false
+	tail := s[2:]
+	if b[0] == '"' || b[0] == '\'' {
+		// strings may be quoted with either kind of quote
+		c = rune(b[0])
+	} else {
+		c, multibyte, tail, err = strconv.UnquoteChar(s, '"')
+		if err != nil {
+			r.fail(fmt.Errorf("Invalid escape sequence %q: %w", s, err))
+			return 0
+		}
+	}
+	r.msg.Discard(len(s) - len(tail) - 1)
+
+	d := r.runeTmp[:1]
+	if c < utf8.RuneSelf || !multibyte {
+		d[0] = byte(c)
+	} else {
+		d = r.runeTmp[:utf8.EncodeRune(r.runeTmp[:], c)]
+	}
+	n := copy(p, d)
+	r.pending = append(r.pending[:0], d[n:]...)
+	return n
+}
+
+// readHex decodes the hex digits of a blob into p.
+func (r *valueReader) readHex(p []byte) int {
+	n := 0
+	for n < len(p) {
+		b, err := r.msg.ReadByte()
+		if err != nil {
+			r.fail(err)
+			return n
+		}
+		if b == '\t' || b == ']' {
+			r.done = true
+			return n
+		}
+		b2, err := r.msg.ReadByte()
+		if err != nil {
+			r.fail(err)
+			return n
+		}
+		if _, err := hex.Decode(p[n:n+1], []byte{b, b2}); err != nil {
+			r.fail(fmt.Errorf("Invalid blob value: %w", err))
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// readPlain copies a value that is neither quoted nor a blob into p.
+func (r *valueReader) readPlain(p []byte) int {
+	n := 0
+	for n < len(p) {
+		b, err := r.msg.ReadByte()
+		if err != nil {
+			r.fail(err)
+			return n
+		}
+		if b == '\t' || b == ']' {
+			r.done = true
+			return n
+		}
+		p[n] = b
+		n++
+	}
+	return n
+}
+
+// Close reads the rest of the response, including the part of the value
+// that was not read, and releases the rest of the result on the server.
+func (r *valueReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	defer r.stop()
+	if r.err != nil {
+		// the connection is closed already
+		return nil
+	}
+
+	if _, err := io.Copy(ioutil.Discard, r.msg); err != nil {
+		return r.fail(err)
+	}
+	if r.more {
+		if _, err := r.conn.cmd(fmt.Sprintf("Xclose %d", r.queryId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
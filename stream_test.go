@@ -0,0 +1,171 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// streamServer answers every query with a result of a single column of the
+// given type holding value, or with response for other commands.
+func streamServer(cmds chan<- string, columnType, value string) func(*MapiConn) {
+	return serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if !strings.HasPrefix(cmd, "sSELECT") {
+			return ""
+		}
+		return "&1 3 1 1 1\n" +
+			"% .t # table_name\n" +
+			"% v # name\n" +
+			"% " + columnType + " # type\n" +
+			"% 0 # length\n" +
+			"[ " + value + "\t]\n"
+	})
+}
+
+func openStream(t *testing.T, srv *fakeServer) *Conn {
+	c, err := (&Driver{}).Open(srv.dsn())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	return c.(*Conn)
+}
+
+func TestQueryReaderClob(t *testing.T) {
+	// several megabytes of text with escapes and multibyte characters
+	line := "line of \"clob\" text, naïve 東京 \\ €\n"
+	text := strings.Repeat(line, 100000)
+	escaped := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(text)
+
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, streamServer(cmds, "clob", "\""+escaped+"\""))
+	defer srv.Close()
+	conn := openStream(t, srv)
+	defer conn.Close()
+
+	r, err := conn.QueryReader(context.Background(), "SELECT c FROM t WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		t.Fatalf("Error reading value: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Error closing reader: %v", err)
+	}
+	if n != int64(len(text)) {
+		t.Errorf("Invalid length: %d, expected: %d", n, len(text))
+	}
+	if sum := sha256.Sum256([]byte(text)); string(h.Sum(nil)) != string(sum[:]) {
+		t.Errorf("Invalid checksum of the value read")
+	}
+
+	// the connection is usable again
+	if _, err := conn.execute("SET SCHEMA sys"); err != nil {
+		t.Errorf("Error using the connection after reading: %v", err)
+	}
+	expectCommands(t, cmds, "sSELECT c FROM t WHERE id = 1;", "sSET SCHEMA sys;")
+}
+
+func TestQueryReaderBlob(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, streamServer(cmds, "blob", strings.Repeat("00FF7f", 10000)))
+	defer srv.Close()
+	conn := openStream(t, srv)
+	defer conn.Close()
+
+	r, err := conn.QueryReader(context.Background(), "SELECT b FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Error reading value: %v", err)
+	}
+	if e := strings.Repeat("\x00\xff\x7f", 10000); string(b) != e {
+		t.Errorf("Invalid value of %d bytes, expected %d bytes", len(b), len(e))
+	}
+}
+
+func TestQueryReaderNoValue(t *testing.T) {
+	type tc struct {
+		response string
+		err      error
+		msg      string
+	}
+	tcs := []tc{
+		tc{"&1 3 0 1 0\n% .t # table_name\n% v # name\n% clob # type\n% 0 # length\n", sql.ErrNoRows, ""},
+		tc{"&1 3 1 1 1\n% .t # table_name\n% v # name\n% clob # type\n% 0 # length\n[ NULL\t]\n", ErrNullValue, ""},
+		tc{"!42S02!SELECT: no such table 't'\n", nil, "no such table"},
+	}
+	for _, c := range tcs {
+		srv := newFakeServer(t, serveCommands(func(cmd string) string {
+			return c.response
+		}))
+		conn := openStream(t, srv)
+
+		r, err := conn.QueryReader(context.Background(), "SELECT v FROM t")
+		if r != nil {
+			t.Errorf("Reader returned for %q", c.response)
+		}
+		if c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("Invalid error for %q: %v, expected: %v", c.response, err, c.err)
+		}
+		if c.msg != "" && (err == nil || !strings.Contains(err.Error(), c.msg)) {
+			t.Errorf("Invalid error for %q: %v, expected: %s", c.response, err, c.msg)
+		}
+		if !conn.IsValid() {
+			t.Errorf("Connection closed after %q", c.response)
+		}
+		conn.Close()
+		srv.Close()
+	}
+}
+
+func TestQueryReaderCloseEarly(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		if !strings.HasPrefix(cmd, "sSELECT") {
+			return ""
+		}
+		// the server keeps the second row
+		return "&1 3 2 1 1\n" +
+			"% .t # table_name\n" +
+			"% v # name\n" +
+			"% clob # type\n" +
+			"% 0 # length\n" +
+			"[ \"" + strings.Repeat("x", 100000) + "\"\t]\n"
+	}))
+	defer srv.Close()
+	conn := openStream(t, srv)
+	defer conn.Close()
+
+	r, err := conn.QueryReader(context.Background(), "SELECT v FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(r, b); err != nil || string(b) != "xxxxxxxxxx" {
+		t.Errorf("Invalid value read: %q (%v)", b, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Error closing reader: %v", err)
+	}
+	if _, err := conn.execute("SET SCHEMA sys"); err != nil {
+		t.Errorf("Error using the connection after closing the reader: %v", err)
+	}
+	expectCommands(t, cmds, "sSELECT v FROM t;", "Xclose 3", "sSET SCHEMA sys;")
+}
@@ -0,0 +1,242 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// insertMaxRows is the number of rows InsertStruct inserts with
+	// one statement at most.
+	insertMaxRows = 1000
+
+	// insertMaxSize is the size in bytes InsertStruct keeps its
+	// statements below, unless max_statement_size is smaller.
+	insertMaxSize = 1 << 20
+)
+
+// ScanStruct scans the current row of rows into the struct dest points
+// to. A column is stored in the exported field tagged with its name, as
+// in `monetdb:"name"`, or else in the field with its name, ignoring case.
+// Fields tagged `monetdb:"-"` are skipped. A nullable column is scanned
+// into a pointer field, which is set to nil for NULL. A column without a
+// field is an error.
+func ScanStruct(rows *sql.Rows, dest interface{}) error {
+	return scanStruct(rows, dest, true)
+}
+
+// ScanStructLenient is like ScanStruct, but skips columns without a
+// field.
+func ScanStructLenient(rows *sql.Rows, dest interface{}) error {
+	return scanStruct(rows, dest, false)
+}
+
+func scanStruct(rows *sql.Rows, dest interface{}, strict bool) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Cannot scan into %T, expected a pointer to a struct", dest)
+	}
+	v = v.Elem()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	fields := structFields(v.Type())
+	targets := make([]interface{}, len(columns))
+	for i, c := range columns {
+		f, ok := fields[strings.ToLower(c)]
+		if !ok {
+			if strict {
+				return fmt.Errorf("No field for column %s in %s", c, v.Type())
+			}
+			targets[i] = new(interface{})
+			continue
+		}
+		targets[i] = v.FieldByIndex(f).Addr().Interface()
+	}
+	return rows.Scan(targets...)
+}
+
+// structFields returns the index of the exported fields of a struct type
+// by their lowercased column name.
+func structFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		name := f.Tag.Get("monetdb")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Index
+	}
+	return fields
+}
+
+// InsertStruct inserts the structs of the slice rows, or the structs its
+// elements point to, into a table with multi-row INSERT statements. The
+// table name is used as is. A field is stored in the column it is tagged
+// with, as in `monetdb:"name"`, or else in the column with its name in
+// lower case. Fields tagged `monetdb:"-"` and unexported fields are
+// skipped.
+//
+// Large slices are inserted with several statements, which stay below
+// the max_statement_size of the connection. In autocommit mode each one
+// commits on its own, use a transaction to insert all rows or none.
+func InsertStruct(db *sql.DB, table string, rows interface{}) (sql.Result, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("Cannot insert %T, expected a slice of structs", rows)
+	}
+	t := v.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Cannot insert %T, expected a slice of structs", rows)
+	}
+
+	names, index, err := structColumns(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No columns to insert in %s", t)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(names, ", "))
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	maxSize := insertMaxSize
+	conn.Raw(func(dc interface{}) error {
+		if c, ok := dc.(*Conn); ok && c.config.MaxStatementSize > 0 && c.config.MaxStatementSize < maxSize {
+			maxSize = c.config.MaxStatementSize
+		}
+		return nil
+	})
+
+	var total Result
+	var b strings.Builder
+	n := 0
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		res, err := conn.ExecContext(ctx, b.String())
+		b.Reset()
+		n = 0
+		if err != nil {
+			return err
+		}
+		affected, _ := res.RowsAffected()
+		total.rowsAffected += int(affected)
+		id, _ := res.LastInsertId()
+		total.lastInsertId = int(id)
+		return nil
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		row, err := structValues(v.Index(i), index)
+		if err != nil {
+			return total, fmt.Errorf("Invalid row %d: %w", i+1, err)
+		}
+		if n > 0 && (n >= insertMaxRows || b.Len()+len(row)+2 > maxSize) {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+		if n == 0 {
+			b.WriteString(prefix)
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(row)
+		n++
+	}
+	if err := flush(); err != nil {
+		return total, err
+	}
+	return total, nil
+}
+
+// structColumns returns the quoted column names of the exported fields
+// of a struct type, and the index of the fields, in the order of the
+// fields.
+func structColumns(t reflect.Type) ([]string, [][]int, error) {
+	var names []string
+	var index [][]int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("monetdb")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		quoted, err := QuoteIdentifier(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, quoted)
+		index = append(index, f.Index)
+	}
+	return names, index, nil
+}
+
+// structValues returns the fields of a struct, or the struct v points to,
+// as the parenthesized list of values of an INSERT statement.
+func structValues(v reflect.Value, index [][]int) (string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", fmt.Errorf("Row is a nil pointer")
+		}
+		v = v.Elem()
+	}
+
+	var b strings.Builder
+	b.WriteString("(")
+	for i, idx := range index {
+		val := v.FieldByIndex(idx).Interface()
+		if vr, ok := val.(driver.Valuer); ok {
+			var err error
+			if val, err = vr.Value(); err != nil {
+				return "", err
+			}
+		}
+		s, err := convertToMonet(val)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(s)
+	}
+	b.WriteString(")")
+	return b.String(), nil
+}
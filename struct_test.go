@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestScanStruct(t *testing.T) {
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		return "&1 0 2 4 2\n" +
+			"% .t,\t.t,\t.t,\t.t # table_name\n" +
+			"% id,\tfull_name,\tscore,\textra # name\n" +
+			"% int,\tvarchar,\tdouble,\tint # type\n" +
+			"% 1,\t5,\t24,\t1 # length\n" +
+			"[ 1,\t\"alice\",\t2.5,\t0\t]\n" +
+			"[ 2,\t\"bob\",\tNULL,\t0\t]\n"
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	type person struct {
+		ID    int
+		Name  string `monetdb:"full_name"`
+		Score *float64
+		Note  string `monetdb:"-"`
+	}
+
+	rows, err := db.Query("SELECT id, full_name, score, extra FROM t")
+	if err != nil {
+		t.Fatalf("Error querying: %v", err)
+	}
+	defer rows.Close()
+
+	var p []person
+	for rows.Next() {
+		var v person
+		err := ScanStruct(rows, &v)
+		if err == nil || !strings.Contains(err.Error(), "No field for column extra") {
+			t.Errorf("Invalid error: %v, expected: No field for column extra", err)
+		}
+		if err := ScanStructLenient(rows, &v); err != nil {
+			t.Fatalf("Error scanning: %v", err)
+		}
+		p = append(p, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Error reading rows: %v", err)
+	}
+
+	if len(p) != 2 {
+		t.Fatalf("Invalid number of rows: %d, expected: 2", len(p))
+	}
+	if p[0].ID != 1 || p[0].Name != "alice" || p[0].Score == nil || *p[0].Score != 2.5 {
+		t.Errorf("Invalid row: %+v", p[0])
+	}
+	if p[1].ID != 2 || p[1].Name != "bob" || p[1].Score != nil {
+		t.Errorf("Invalid row: %+v", p[1])
+	}
+
+	if err := ScanStruct(rows, p[0]); err == nil {
+		t.Errorf("Expected error scanning into a struct value")
+	}
+}
+
+type insertedPerson struct {
+	ID    int
+	Name  string `monetdb:"full_name"`
+	Score *float64
+	Note  string `monetdb:"-"`
+	note  string
+}
+
+func TestInsertStruct(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		return fmt.Sprintf("&2 %d -1\n", strings.Count(cmd, "), (")+1)
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	score := 2.5
+	rows := []insertedPerson{
+		{ID: 1, Name: "alice", Score: &score, Note: "x", note: "y"},
+		{ID: 2, Name: "bob's"},
+		{ID: 3, Name: "carol"},
+	}
+	res, err := InsertStruct(db, "people", rows)
+	if err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Errorf("Invalid rows affected: %d (%v), expected: 3", n, err)
+	}
+
+	expectCommands(t, cmds, `sINSERT INTO people ("id", "full_name", "score") VALUES `+
+		`(1, 'alice', 2.5), (2, 'bob\'s', NULL), (3, 'carol', NULL);`)
+
+	if _, err := InsertStruct(db, "people", rows[0]); err == nil {
+		t.Errorf("Expected error inserting a struct that is not in a slice")
+	}
+}
+
+func TestInsertStructChunks(t *testing.T) {
+	cmds := make(chan string, 10)
+	srv := newFakeServer(t, serveCommands(func(cmd string) string {
+		cmds <- cmd
+		return fmt.Sprintf("&2 %d -1\n", strings.Count(cmd, "), (")+1)
+	}))
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn()+"?max_statement_size=85")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	rows := []*insertedPerson{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}, {ID: 3, Name: "c"}}
+	res, err := InsertStruct(db, "p", rows)
+	if err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Errorf("Invalid rows affected: %d (%v), expected: 3", n, err)
+	}
+
+	expectCommands(t, cmds,
+		`sINSERT INTO p ("id", "full_name", "score") VALUES (1, 'a', NULL), (2, 'b', NULL);`,
+		`sINSERT INTO p ("id", "full_name", "score") VALUES (3, 'c', NULL);`)
+}
@@ -16,12 +16,15 @@ func newTx(c *Conn) *Tx {
 	}
 }
 
+// Commit commits the transaction. The connection is back in autocommit
+// mode afterwards, even if the commit fails; MonetDB aborts a transaction
+// it cannot commit.
 func (t *Tx) Commit() error {
-	_, err := t.conn.execute("COMMIT")
-	return err
+	return t.conn.endTx("COMMIT")
 }
 
+// Rollback aborts the transaction and returns the connection to
+// autocommit mode.
 func (t *Tx) Rollback() error {
-	_, err := t.conn.execute("ROLLBACK")
-	return err
+	return t.conn.endTx("ROLLBACK")
 }
@@ -5,7 +5,13 @@
 package monetdb
 
 import (
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,6 +20,13 @@ type Time struct {
 	Hour, Min, Sec int
 }
 
+// TimeTZ represents MonetDB's timetz datatype, a time of day with the
+// offset from UTC it is in, in seconds east of UTC.
+type TimeTZ struct {
+	Time
+	Offset int
+}
+
 // Time represents MonetDB's Date datatype.
 type Date struct {
 	Year  int
@@ -21,6 +34,132 @@ type Date struct {
 	Day   int
 }
 
+// TimestampTZ wraps a time.Time that is sent to MonetDB as a timestamp
+// with time zone. Unlike a plain time.Time, which is written as a naive
+// timestamp, its UTC offset is kept so the server stores the same instant.
+type TimestampTZ struct {
+	time.Time
+}
+
+// Timestamp wraps a time.Time that is sent to MonetDB as a timestamp with
+// Precision digits after the decimal point of the seconds, 0 to 6, to
+// match the precision of a TIMESTAMP(p) column. Further digits are
+// truncated. A plain time.Time is sent with microsecond precision.
+type Timestamp struct {
+	Time      time.Time
+	Precision int
+}
+
+// Interval represents MonetDB's interval types that cannot be held by a
+// time.Duration. A month_interval, such as INTERVAL '1-06' YEAR TO
+// MONTH, is read as a number of Months.
+type Interval struct {
+	Months   int64
+	Duration time.Duration
+}
+
+// String returns a string representation of an Interval in the form
+// MonetDB uses: a number of months, or a number of seconds.
+func (i Interval) String() string {
+	switch {
+	case i.Duration == 0:
+		return strconv.FormatInt(i.Months, 10)
+	case i.Months == 0:
+		return strconv.FormatFloat(i.Duration.Seconds(), 'f', -1, 64)
+	}
+	return fmt.Sprintf("%d months %v", i.Months, i.Duration)
+}
+
+// OID represents MonetDB's oid datatype, the object identifiers found in
+// the system catalog.
+type OID uint64
+
+// Raw is an SQL fragment that is sent to MonetDB verbatim in place of an
+// argument, e.g. Raw("current_timestamp"). It is neither quoted nor
+// escaped, so it must never contain untrusted input.
+type Raw string
+
+// BoolAsInt is a bool that is sent to MonetDB as 1 or 0, e.g. for a
+// TINYINT column used as a flag, instead of true or false. A bool bound
+// to an integer parameter of a prepared statement is sent that way too.
+type BoolAsInt bool
+
+// Numeric is a number given as text, such as "12.50", that is sent to
+// MonetDB as an unquoted numeric literal rather than a string, so it is
+// not taken for a varchar. Text that is not a number is rejected.
+type Numeric string
+
+// Decimal represents MonetDB's Decimal datatype. Its value is Unscaled
+// divided by 10 to the power of Scale, so it holds any decimal without
+// rounding. It is sent to MonetDB as an unquoted numeric literal.
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int
+}
+
+var decimalRe = regexp.MustCompile(`^([-+]?)(\d*)(?:\.(\d*))?$`)
+
+// ParseDecimal parses a decimal in the form "[-]123.45". The number of
+// digits after the decimal point sets the scale. A negative zero, such as
+// "-0.00", is zero.
+func ParseDecimal(s string) (Decimal, error) {
+	m := decimalRe.FindStringSubmatch(s)
+	if m == nil || m[2]+m[3] == "" {
+		return Decimal{}, fmt.Errorf("Invalid decimal: %q", s)
+	}
+
+	u, _ := new(big.Int).SetString(m[2]+m[3], 10)
+	if m[1] == "-" {
+		u.Neg(u)
+	}
+	return Decimal{Unscaled: u, Scale: len(m[3])}, nil
+}
+
+// String returns a string representation of a Decimal
+// in the form "[-]123.45", with Scale digits after the decimal point.
+func (d Decimal) String() string {
+	if d.Unscaled == nil {
+		d.Unscaled = new(big.Int)
+	}
+
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	if d.Scale > 0 {
+		if len(digits) <= d.Scale {
+			digits = strings.Repeat("0", d.Scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.Scale] + "." + digits[len(digits)-d.Scale:]
+	}
+	if d.Unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// Scan implements sql.Scanner, so a decimal can be read back exactly from
+// its textual form.
+func (d *Decimal) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("Cannot scan %T into Decimal", src)
+	}
+
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
 // String returns a string representation of a Time
 // in the form "HH:YY:MM".
 func (t Time) String() string {
@@ -32,6 +171,16 @@ func (t Time) Time() time.Time {
 	return time.Date(1970, time.January, 1, t.Hour, t.Min, t.Sec, 0, time.UTC)
 }
 
+// String returns a string representation of a TimeTZ
+// in the form "HH:MM:SS+hh:mm".
+func (t TimeTZ) String() string {
+	sign, offset := '+', t.Offset
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%s%c%02d:%02d", t.Time, sign, offset/3600, offset%3600/60)
+}
+
 // String returns a string representation of a Date
 // in the form "YYYY-MM-DD"
 func (d Date) String() string {
@@ -54,3 +203,78 @@ func GetDate(t time.Time) Date {
 	year, month, day := t.Date()
 	return Date{year, month, day}
 }
+
+// HexBlob is a Scan destination that reads a BLOB value as its uppercase
+// hex encoding, such as "DEADBEEF", e.g. for logging. A NULL value scans
+// as "". Scan a BLOB into a []byte for its raw bytes, and into a string
+// for the raw bytes as a string.
+type HexBlob string
+
+// Scan implements sql.Scanner.
+func (h *HexBlob) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		*h = HexBlob(strings.ToUpper(hex.EncodeToString(v)))
+	case nil:
+		*h = ""
+	default:
+		return fmt.Errorf("Cannot scan %T into HexBlob", src)
+	}
+	return nil
+}
+
+// UUID is a Scan destination and an argument for a MonetDB UUID. Scanned
+// into a string, a uuid is its text, such as
+// "65bca9e4-7e1c-4c6d-9f39-4b9a1e6f0c2a".
+type UUID [16]byte
+
+// ParseUUID parses the text of a uuid, with or without its hyphens.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	h := strings.TrimSpace(s)
+	if len(h) == 36 {
+		if h[8] != '-' || h[13] != '-' || h[18] != '-' || h[23] != '-' {
+			return u, fmt.Errorf("Invalid UUID: %q", s)
+		}
+		h = h[:8] + h[9:13] + h[14:18] + h[19:23] + h[24:]
+	}
+	if len(h) != 32 {
+		return u, fmt.Errorf("Invalid UUID: %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(h)); err != nil {
+		return u, fmt.Errorf("Invalid UUID: %q", s)
+	}
+	return u, nil
+}
+
+// String returns the uuid in its canonical form, in lowercase with
+// hyphens.
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// Scan implements sql.Scanner.
+func (u *UUID) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("Cannot scan %T into UUID", src)
+	}
+
+	v, err := ParseUUID(s)
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (u UUID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
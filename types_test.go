@@ -116,3 +116,82 @@ func TestDateFromTime(t *testing.T) {
 		t.Errorf("Invalid day: %d, expected: %d", v.Day, day)
 	}
 }
+
+func TestDecimalString(t *testing.T) {
+	for _, s := range []string{"12345.6789", "-0.05", "0.001", "42", "-7.10"} {
+		d, err := ParseDecimal(s)
+		if err != nil {
+			t.Fatalf("Error parsing decimal %s: %v", s, err)
+		}
+		if d.String() != s {
+			t.Errorf("Invalid decimal: %s, expected: %s", d, s)
+		}
+	}
+
+	for _, s := range []string{"", "-", ".", "1.2.3", "1e5", "12a"} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("Expected error parsing decimal %q", s)
+		}
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	type tc struct {
+		v        string
+		unscaled int64
+		scale    int
+		s        string
+	}
+	tcs := []tc{
+		tc{"-0.0050", -50, 4, "-0.0050"},
+		tc{".5", 5, 1, "0.5"},
+		tc{"-.5", -5, 1, "-0.5"},
+		tc{"0", 0, 0, "0"},
+		tc{"-0.00", 0, 2, "0.00"},
+		tc{"100.00", 10000, 2, "100.00"},
+		tc{"+7", 7, 0, "7"},
+	}
+
+	for _, c := range tcs {
+		d, err := ParseDecimal(c.v)
+		if err != nil {
+			t.Fatalf("Error parsing decimal %s: %v", c.v, err)
+		}
+		if d.Unscaled.Int64() != c.unscaled || d.Scale != c.scale {
+			t.Errorf("Invalid decimal %s: %v, scale %d, expected: %d, scale %d",
+				c.v, d.Unscaled, d.Scale, c.unscaled, c.scale)
+		}
+		if d.String() != c.s {
+			t.Errorf("Invalid decimal: %s, expected: %s", d, c.s)
+		}
+	}
+}
+
+func TestIntervalString(t *testing.T) {
+	tcs := map[Interval]string{
+		Interval{Months: 18}:                         "18",
+		Interval{Months: -27}:                        "-27",
+		Interval{Duration: 90500 * time.Millisecond}: "90.5",
+	}
+	for i, e := range tcs {
+		if s := i.String(); s != e {
+			t.Errorf("Invalid string: %s, expected: %s", s, e)
+		}
+	}
+}
+
+func TestParseUUID(t *testing.T) {
+	e := "65bca9e4-7e1c-4c6d-9f39-4b9a1e6f0c2a"
+	for _, s := range []string{e, "65BCA9E4-7E1C-4C6D-9F39-4B9A1E6F0C2A", "65bca9e47e1c4c6d9f394b9a1e6f0c2a"} {
+		u, err := ParseUUID(s)
+		if err != nil || u.String() != e {
+			t.Errorf("Invalid uuid for %s: %s (%v), expected: %s", s, u, err, e)
+		}
+	}
+
+	for _, s := range []string{"", "65bca9e4", "65bca9e4-7e1c-4c6d-9f39-4b9a1e6f0c2g", "65bca9e4+7e1c-4c6d-9f39-4b9a1e6f0c2a"} {
+		if _, err := ParseUUID(s); err == nil {
+			t.Errorf("Expected error parsing uuid %q", s)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithConn calls fn with a single connection of the pool, which is
+// returned to the pool afterwards. Session state, such as local temporary
+// tables and session variables, lives on a connection, while statements
+// run directly on a sql.DB may each use a different one. Run statements
+// that depend on such state through the sql.Conn passed to fn.
+//
+// Returning the connection to the pool does not drop its temporary
+// tables, only a transaction left open is rolled back.
+func WithConn(ctx context.Context, db *sql.DB, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(conn)
+}
@@ -0,0 +1,67 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package monetdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWithConnTempTable(t *testing.T) {
+	// every connection has a temporary table of its own
+	srv := newFakeServer(t, func(m *MapiConn) {
+		rows := -1
+		serveCommands(func(cmd string) string {
+			switch {
+			case strings.HasPrefix(cmd, "sCREATE LOCAL TEMPORARY TABLE"):
+				rows = 0
+				return "&3\n"
+			case rows < 0:
+				return "!42S02!no such table 'tmp'\n"
+			case strings.HasPrefix(cmd, "sINSERT"):
+				rows++
+				return "&2 1 -1\n"
+			}
+			return "&1 0 1 1 1\n% .tmp # table_name\n% n # name\n% bigint # type\n% 1 # length\n" +
+				fmt.Sprintf("[ %d\t]\n", rows)
+		})(m)
+	})
+	defer srv.Close()
+
+	db, err := sql.Open("monetdb", srv.dsn())
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	// keep a second connection in the pool
+	busy, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	busy.Close()
+
+	var n int
+	err = WithConn(context.Background(), db, func(conn *sql.Conn) error {
+		ctx := context.Background()
+		if _, err := conn.ExecContext(ctx, "CREATE LOCAL TEMPORARY TABLE tmp (i INT) ON COMMIT PRESERVE ROWS"); err != nil {
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := conn.ExecContext(ctx, "INSERT INTO tmp VALUES (1)"); err != nil {
+				return err
+			}
+		}
+		return conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM tmp").Scan(&n)
+	})
+	if err != nil {
+		t.Fatalf("Error using temporary table: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Invalid number of rows: %d, expected: 3", n)
+	}
+}